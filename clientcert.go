@@ -0,0 +1,122 @@
+package httpcontrol
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+)
+
+// ClientCert is a client certificate for mutual TLS that is loaded from disk,
+// and reloaded when its files change so that rotated certificates are picked
+// up without restarting. Since resumed TLS sessions keep the certificate they
+// were established with, the Transport does not cache sessions by default when
+// a ClientCert is used. A ClientCert may be shared between Transports, but
+// must not be copied after first use.
+type ClientCert struct {
+	// CertFile and KeyFile are the PEM encoded certificate chain and private
+	// key, as accepted by tls.LoadX509KeyPair.
+	CertFile, KeyFile string
+
+	// CheckInterval is how often the files are checked for changes when the
+	// certificate is used. The default is 10 seconds.
+	CheckInterval time.Duration
+
+	// OnReloadError, if non-nil, is called when loading the certificate
+	// fails. The previously loaded certificate, if any, keeps being used. It
+	// may be called concurrently, and may use the ClientCert.
+	OnReloadError func(error)
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	expiry  time.Time
+	checked time.Time
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func (c *ClientCert) checkInterval() time.Duration {
+	if c.CheckInterval == 0 {
+		return 10 * time.Second
+	}
+	return c.CheckInterval
+}
+
+// Reload loads the certificate from disk, regardless of whether the files
+// changed. It can be used to check the certificate before making requests.
+func (c *ClientCert) Reload() error {
+	c.mu.Lock()
+	err := c.reload(true)
+	c.mu.Unlock()
+	c.failed(err)
+	return err
+}
+
+// reload loads the certificate if forced or if its files changed. Must be
+// called with the lock held.
+func (c *ClientCert) reload(force bool) error {
+	c.checked = time.Now()
+	certInfo, err := os.Stat(c.CertFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(c.KeyFile)
+	if err != nil {
+		return err
+	}
+	if !force && c.cert != nil &&
+		certInfo.ModTime().Equal(c.certMod) && keyInfo.ModTime().Equal(c.keyMod) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	c.cert = &cert
+	c.expiry = leaf.NotAfter
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// failed reports a non-nil reload error. It must be called without the lock
+// held, so that OnReloadError may use the ClientCert.
+func (c *ClientCert) failed(err error) {
+	if err != nil && c.OnReloadError != nil {
+		c.OnReloadError(err)
+	}
+}
+
+// GetClientCertificate returns the current certificate, reloading it first
+// if its files changed. It is suitable for use as the GetClientCertificate
+// function of a tls.Config.
+func (c *ClientCert) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	var err error
+	if c.cert == nil || time.Since(c.checked) >= c.checkInterval() {
+		err = c.reload(false)
+	}
+	cert := c.cert
+	c.mu.Unlock()
+	c.failed(err)
+	if cert == nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Expiry returns the expiry time of the loaded certificate, or the zero time
+// if none was loaded yet.
+func (c *ClientCert) Expiry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expiry
+}
@@ -0,0 +1,116 @@
+package httpcontrol_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+// writeCert writes a self-signed certificate expiring at notAfter and its key
+// to the files, with the given modification time.
+func writeCert(t *testing.T, certFile, keyFile string, notAfter, mod time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ensure.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ensure.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	ensure.Nil(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	ensure.Nil(t, os.WriteFile(certFile, certPEM, 0600))
+	ensure.Nil(t, os.WriteFile(keyFile, keyPEM, 0600))
+	ensure.Nil(t, os.Chtimes(certFile, mod, mod))
+	ensure.Nil(t, os.Chtimes(keyFile, mod, mod))
+}
+
+func TestClientCertReload(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	second := first.Add(24 * time.Hour)
+	writeCert(t, certFile, keyFile, first, time.Now().Add(-time.Minute))
+
+	var expiry time.Time
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expiry = r.TLS.PeerCertificates[0].NotAfter
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	var reloadErr error
+	cert := &httpcontrol.ClientCert{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CheckInterval: time.Nanosecond,
+		OnReloadError: func(err error) { reloadErr = err },
+	}
+	ensure.Nil(t, cert.Reload())
+	ensure.True(t, cert.Expiry().Equal(first))
+	transport := &httpcontrol.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ClientCert:        cert,
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transport}
+	get := func() {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		res.Body.Close()
+	}
+
+	get()
+	ensure.True(t, expiry.Equal(first))
+
+	writeCert(t, certFile, keyFile, second, time.Now())
+	get()
+	ensure.True(t, expiry.Equal(second))
+	ensure.True(t, cert.Expiry().Equal(second))
+
+	ensure.Nil(t, os.Remove(keyFile))
+	get()
+	ensure.NotNil(t, reloadErr)
+	ensure.True(t, expiry.Equal(second))
+}
+
+func TestClientCertReloadErrorCallbackUsesCert(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	var cert *httpcontrol.ClientCert
+	cert = &httpcontrol.ClientCert{
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		OnReloadError: func(err error) {
+			ensure.True(t, cert.Expiry().IsZero())
+		},
+	}
+	done := make(chan error)
+	go func() { done <- cert.Reload() }()
+	select {
+	case err := <-done:
+		ensure.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReloadError deadlocked")
+	}
+}
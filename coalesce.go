@@ -0,0 +1,159 @@
+package httpcontrol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultMaxCoalescedBodySize = 1 << 20
+
+// errCoalescedBodyTooLarge is the error of a shared request whose response
+// body exceeds the MaxCoalescedBodySize. It is never returned to callers.
+var errCoalescedBodyTooLarge = errors.New("httpcontrol: coalesced response body too large")
+
+// CoalesceByURL is a CoalesceKey that collapses GET requests for the same URL.
+// Note that it ignores request headers, so it should only be used when all
+// callers send equivalent headers.
+func CoalesceByURL(req *http.Request) string {
+	return req.URL.String()
+}
+
+// coalescedCall is an in-flight or completed request shared by all callers
+// with the same key.
+type coalescedCall struct {
+	done chan struct{}
+	res  *http.Response
+	body []byte
+	err  error
+
+	// The request of the caller that started the call. The Stats of the
+	// other callers are reported as Coalesced.
+	first *http.Request
+
+	// The callers still waiting, guarded by the coalescer's lock. The shared
+	// request is canceled once they all gave up.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// response returns an independent copy of the shared response for req.
+func (c *coalescedCall) response(req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	res := new(http.Response)
+	*res = *c.res
+	res.Header = c.res.Header.Clone()
+	res.Trailer = c.res.Trailer.Clone()
+	res.Request = req
+	res.Body = ioutil.NopCloser(bytes.NewReader(c.body))
+	return res, nil
+}
+
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalesce performs req, or waits for an identical in-flight request to
+// finish, and returns a copy of the response. The shared request is not tied
+// to the context of any one caller: each caller may give up on its own, and the
+// shared request is only canceled when all of them have. Its body is fully
+// buffered so that it can be handed out to every caller, unless it exceeds the
+// MaxCoalescedBodySize and every caller falls back to a request of its own.
+func (t *Transport) coalesce(req *http.Request, key string) (*http.Response, error) {
+	t.coalescer.mu.Lock()
+	if t.coalescer.calls == nil {
+		t.coalescer.calls = make(map[string]*coalescedCall)
+	}
+	c, ok := t.coalescer.calls[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+		c = &coalescedCall{done: make(chan struct{}), first: req, cancel: cancel}
+		t.coalescer.calls[key] = c
+		go t.runCoalesced(c, key, req.WithContext(ctx))
+	}
+	c.waiters++
+	t.coalescer.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case <-c.done:
+		if c.err == errCoalescedBodyTooLarge {
+			return t.admit(req)
+		}
+		res, err := c.response(req)
+		t.coalescedStats(c, req, res, err, start)
+		return res, err
+	case <-req.Context().Done():
+		t.coalescer.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if t.coalescer.calls[key] == c {
+				delete(t.coalescer.calls, key)
+			}
+		}
+		t.coalescer.mu.Unlock()
+		err := req.Context().Err()
+		t.coalescedStats(c, req, nil, err, start)
+		return nil, err
+	}
+}
+
+// coalescedStats reports the Stats of a caller that waited for the request
+// of another. The Stats of the shared request itself are reported when it is
+// performed.
+func (t *Transport) coalescedStats(c *coalescedCall, req *http.Request, res *http.Response, err error, start time.Time) {
+	if t.Stats == nil || req == c.first {
+		return
+	}
+	stats := &Stats{
+		Request:   req,
+		Response:  res,
+		Error:     err,
+		Priority:  priorityOf(req.Context()),
+		Coalesced: true,
+	}
+	stats.Duration.Header = time.Since(start)
+	if res != nil {
+		stats.Proto = res.Proto
+	}
+	t.Stats(stats)
+}
+
+// runCoalesced performs the shared request of the call.
+func (t *Transport) runCoalesced(c *coalescedCall, key string, req *http.Request) {
+	limit := t.MaxCoalescedBodySize
+	if limit == 0 {
+		limit = defaultMaxCoalescedBodySize
+	}
+	c.res, c.err = t.admit(req)
+	if c.err == nil {
+		if c.res.ContentLength > limit {
+			c.err = errCoalescedBodyTooLarge
+		} else {
+			c.body, c.err = ioutil.ReadAll(io.LimitReader(c.res.Body, limit+1))
+			if c.err == nil && int64(len(c.body)) > limit {
+				c.err = errCoalescedBodyTooLarge
+			}
+		}
+		if err := c.res.Body.Close(); c.err == nil {
+			c.err = err
+		}
+	}
+
+	t.coalescer.mu.Lock()
+	if t.coalescer.calls[key] == c {
+		delete(t.coalescer.calls, key)
+	}
+	t.coalescer.mu.Unlock()
+	c.cancel()
+	close(c.done)
+}
@@ -0,0 +1,224 @@
+package httpcontrol_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestCoalesceIdenticalGets(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			<-release
+			w.Header().Set("X-Answer", "42")
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		CoalesceKey: httpcontrol.CoalesceByURL,
+	}
+	client := &http.Client{Transport: transport}
+
+	const callers = 5
+	var started, finished sync.WaitGroup
+	started.Add(callers)
+	finished.Add(callers)
+	responses := make([]*http.Response, callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer finished.Done()
+			started.Done()
+			res, err := client.Get(server.URL)
+			ensure.Nil(t, err)
+			responses[i] = res
+		}(i)
+	}
+	started.Wait()
+	// give the remaining callers time to join the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	finished.Wait()
+
+	ensure.DeepEqual(t, atomic.LoadInt32(&hits), int32(1))
+	for _, res := range responses {
+		ensure.DeepEqual(t, res.Header.Get("X-Answer"), "42")
+		assertResponse(res, t)
+	}
+}
+
+func TestCoalesceEmptyKeyDisables(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		CoalesceKey: func(*http.Request) string { return "" },
+	}
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		assertResponse(res, t)
+	}
+	ensure.DeepEqual(t, atomic.LoadInt32(&hits), int32(2))
+}
+
+func TestCoalesceCallerCancelDoesNotFailOthers(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			<-release
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		CoalesceKey: httpcontrol.CoalesceByURL,
+	}
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		_, err := client.Do(req.WithContext(ctx))
+		leader <- err
+	}()
+	// let the leader start the shared request before the follower joins it
+	time.Sleep(50 * time.Millisecond)
+	follower := make(chan *http.Response, 1)
+	go func() {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		follower <- res
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	ensure.NotNil(t, <-leader)
+	close(release)
+	assertResponse(<-follower, t)
+	ensure.DeepEqual(t, atomic.LoadInt32(&hits), int32(1))
+}
+
+func TestCoalesceAllCallersCancel(t *testing.T) {
+	t.Parallel()
+	canceled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			close(canceled)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		CoalesceKey: httpcontrol.CoalesceByURL,
+	}
+	client := &http.Client{Transport: transport}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := client.Do(req.WithContext(ctx))
+	ensure.NotNil(t, err)
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared request was not canceled")
+	}
+}
+
+func TestCoalesceStatsForEachCaller(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	var shared, coalesced int32
+	transport := &httpcontrol.Transport{
+		CoalesceKey: httpcontrol.CoalesceByURL,
+		Stats: func(stats *httpcontrol.Stats) {
+			ensure.Nil(t, stats.Error)
+			if stats.Coalesced {
+				atomic.AddInt32(&coalesced, 1)
+			} else {
+				atomic.AddInt32(&shared, 1)
+			}
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	const callers = 3
+	var finished sync.WaitGroup
+	finished.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer finished.Done()
+			res, err := client.Get(server.URL)
+			ensure.Nil(t, err)
+			assertResponse(res, t)
+		}()
+	}
+	// give the callers time to join the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	finished.Wait()
+
+	ensure.DeepEqual(t, atomic.LoadInt32(&shared), int32(1))
+	ensure.DeepEqual(t, atomic.LoadInt32(&coalesced), int32(callers-1))
+}
+
+func TestCoalesceLargeBodyNotShared(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) == 1 {
+				<-release
+			}
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		CoalesceKey:          httpcontrol.CoalesceByURL,
+		MaxCoalescedBodySize: int64(len(theAnswer) - 1),
+	}
+	client := &http.Client{Transport: transport}
+
+	const callers = 3
+	var finished sync.WaitGroup
+	finished.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer finished.Done()
+			res, err := client.Get(server.URL)
+			ensure.Nil(t, err)
+			assertResponse(res, t)
+		}()
+	}
+	// give the callers time to join the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	finished.Wait()
+
+	// the shared request, and then one for each caller
+	ensure.DeepEqual(t, atomic.LoadInt32(&hits), int32(callers+1))
+}
@@ -0,0 +1,414 @@
+package httpcontrol
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is serialized as a string such as "1.5s".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes the duration from a string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("httpcontrol: invalid duration %s, expected a string such as \"1s\"", b)
+	}
+	return d.Set(s)
+}
+
+// Set parses the duration from a string, as accepted by time.ParseDuration.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("httpcontrol: %w", err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DNSCacheConfig is the serializable form of a DNSCache.
+type DNSCacheConfig struct {
+	TTL         Duration `json:"ttl,omitempty"`
+	NegativeTTL Duration `json:"negative_ttl,omitempty"`
+	MaxStale    Duration `json:"max_stale,omitempty"`
+}
+
+// HealthCheckConfig is the serializable form of a HealthCheck.
+type HealthCheckConfig struct {
+	Path                string   `json:"path,omitempty"`
+	Interval            Duration `json:"interval,omitempty"`
+	ConsecutiveFailures uint     `json:"consecutive_failures,omitempty"`
+	ErrorRate           float64  `json:"error_rate,omitempty"`
+	MinRequests         uint     `json:"min_requests,omitempty"`
+	Window              Duration `json:"window,omitempty"`
+	BaseEjectionTime    Duration `json:"base_ejection_time,omitempty"`
+	MaxEjectionTime     Duration `json:"max_ejection_time,omitempty"`
+}
+
+// HostConfig is the serializable form of HostSettings. Proxy is a proxy URL.
+type HostConfig struct {
+	RequestTimeout        Duration `json:"request_timeout,omitempty"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout,omitempty"`
+	DialTimeout           Duration `json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout,omitempty"`
+	IdleConnTimeout       Duration `json:"idle_conn_timeout,omitempty"`
+	MaxTries              uint     `json:"max_tries,omitempty"`
+	MaxIdleConnsPerHost   int      `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost       int      `json:"max_conns_per_host,omitempty"`
+	Proxy                 string   `json:"proxy,omitempty"`
+}
+
+// TransportConfig is the serializable form of the Transport settings, for
+// loading them from files and environment variables. The settings that are
+// functions, such as Dial or Stats, are not covered. Unlike TransportFlag, no
+// defaults are applied: unset settings are the zero value of the Transport.
+type TransportConfig struct {
+	DisableKeepAlives      bool     `json:"disable_keepalives,omitempty"`
+	DisableCompression     bool     `json:"disable_compression,omitempty"`
+	MaxIdleConns           int      `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost    int      `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost        int      `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeout        Duration `json:"idle_conn_timeout,omitempty"`
+	MinIdleConns           int      `json:"min_idle_conns,omitempty"`
+	MaxConnLifetime        Duration `json:"max_conn_lifetime,omitempty"`
+	MaxConnLifetimeJitter  Duration `json:"max_conn_lifetime_jitter,omitempty"`
+	DialTimeout            Duration `json:"dial_timeout,omitempty"`
+	DialKeepAlive          Duration `json:"dial_keepalive,omitempty"`
+	DialFallbackDelay      Duration `json:"dial_fallback_delay,omitempty"`
+	DialAddressTimeout     Duration `json:"dial_address_timeout,omitempty"`
+	TLSHandshakeTimeout    Duration `json:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout  Duration `json:"response_header_timeout,omitempty"`
+	ExpectContinueTimeout  Duration `json:"expect_continue_timeout,omitempty"`
+	MaxResponseHeaderBytes int64    `json:"max_response_header_bytes,omitempty"`
+	WriteBufferSize        int      `json:"write_buffer_size,omitempty"`
+	ReadBufferSize         int      `json:"read_buffer_size,omitempty"`
+	ForceAttemptHTTP2      bool     `json:"force_http2,omitempty"`
+	H2C                    bool     `json:"h2c,omitempty"`
+	HTTP2ReadIdleTimeout   Duration `json:"http2_read_idle_timeout,omitempty"`
+	HTTP2PingTimeout       Duration `json:"http2_ping_timeout,omitempty"`
+	RequestTimeout         Duration `json:"request_timeout,omitempty"`
+	RetryAfterTimeout      bool     `json:"retry_after_timeout,omitempty"`
+	MaxTries               uint     `json:"max_tries,omitempty"`
+	Coalesce               bool     `json:"coalesce,omitempty"`
+	MaxCoalescedBodySize   int64    `json:"max_coalesced_body_size,omitempty"`
+	MaxConcurrentRequests  int      `json:"max_concurrent_requests,omitempty"`
+	MaxQueuedRequests      int      `json:"max_queued_requests,omitempty"`
+
+	InsecureSkipVerify  bool                `json:"insecure_skip_verify,omitempty"`
+	CAFile              string              `json:"ca_file,omitempty"`
+	ClientCert          string              `json:"client_cert,omitempty"`
+	ClientKey           string              `json:"client_key,omitempty"`
+	TLSMinVersion       string              `json:"tls_min_version,omitempty"`
+	TLSServerName       string              `json:"tls_server_name,omitempty"`
+	TLSCipherPolicy     string              `json:"tls_cipher_policy,omitempty"`
+	TLSSessionCacheSize int                 `json:"tls_session_cache_size,omitempty"`
+	Pins                map[string][]string `json:"pins,omitempty"`
+
+	HTTPProxy          string            `json:"http_proxy,omitempty"`
+	HTTPSProxy         string            `json:"https_proxy,omitempty"`
+	NoProxy            string            `json:"no_proxy,omitempty"`
+	ProxyFromEnv       bool              `json:"proxy_from_env,omitempty"`
+	ProxyRules         []ProxyRule       `json:"proxy_rules,omitempty"`
+	ProxyUsername      string            `json:"proxy_user,omitempty"`
+	ProxyPassword      string            `json:"proxy_password,omitempty"`
+	ProxyConnectHeader map[string]string `json:"proxy_connect_header,omitempty"`
+	SOCKS5Proxy        string            `json:"socks5_proxy,omitempty"`
+
+	Resolve     map[string]string   `json:"resolve,omitempty"`
+	UnixSockets map[string]string   `json:"unix_sockets,omitempty"`
+	Fallbacks   map[string][]string `json:"fallbacks,omitempty"`
+
+	DNSCache    *DNSCacheConfig       `json:"dns_cache,omitempty"`
+	HealthCheck *HealthCheckConfig    `json:"health_check,omitempty"`
+	Hosts       map[string]HostConfig `json:"hosts,omitempty"`
+}
+
+// LoadConfigJSON reads a TransportConfig from JSON. Unknown keys are errors.
+func LoadConfigJSON(r io.Reader) (*TransportConfig, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	c := new(TransportConfig)
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("httpcontrol: decoding config: %w", err)
+	}
+	return c, nil
+}
+
+// LoadConfigYAML reads a TransportConfig from a simple subset of YAML: block
+// mappings and sequences, plain and quoted scalars, and comments. Keys are
+// the same as for JSON. Scalars are interpreted according to the setting they
+// are for, so tls_min_version: 1.2 is the string "1.2".
+func LoadConfigYAML(r io.Reader) (*TransportConfig, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseYAML(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("httpcontrol: decoding config: %w", err)
+	}
+	j, err := json.Marshal(typeYAML(v, reflect.TypeOf(TransportConfig{})))
+	if err != nil {
+		return nil, err
+	}
+	return LoadConfigJSON(strings.NewReader(string(j)))
+}
+
+// ApplyEnv overlays the environment variables named after the JSON keys of the
+// settings, in upper case and with the prefix, such as
+// HTTPCLIENT_REQUEST_TIMEOUT for the prefix "HTTPCLIENT_". Nested settings
+// join their keys with an underscore, as in HTTPCLIENT_DNS_CACHE_TTL. Map
+// settings are given as comma separated key=value entries, repeated keys
+// adding to list values, and proxy rules as pattern=proxy entries. Hosts
+// cannot be set from the environment.
+func (c *TransportConfig) ApplyEnv(prefix string) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		key, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		name := prefix + strings.ToUpper(key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Ptr {
+			sub := reflect.New(field.Type().Elem())
+			if field.IsNil() {
+				if !hasEnvPrefix(name + "_") {
+					continue
+				}
+				field.Set(sub)
+			}
+			if err := applyEnv(field.Elem(), name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok || field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.Struct {
+			continue
+		}
+		if err := setEnv(field, value); err != nil {
+			return fmt.Errorf("httpcontrol: invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func hasEnvPrefix(prefix string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func setEnv(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		return field.Addr().Interface().(*Duration).Set(value)
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		list := field.Type().Elem().Kind() == reflect.Slice
+		for _, entry := range splitEntries(value) {
+			k, v, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", entry)
+			}
+			if !list {
+				m.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(v))
+				continue
+			}
+			var values []string
+			if existing := m.MapIndex(reflect.ValueOf(k)); existing.IsValid() {
+				values = existing.Interface().([]string)
+			}
+			m.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(append(values, v)))
+		}
+		field.Set(m)
+	case reflect.Slice:
+		var rules []ProxyRule
+		for _, entry := range splitEntries(value) {
+			pattern, proxy, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("expected pattern=proxy, got %q", entry)
+			}
+			rules = append(rules, ProxyRule{Pattern: pattern, Proxy: proxy})
+		}
+		field.Set(reflect.ValueOf(rules))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+func splitEntries(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Transport returns a Transport with the settings. It fails if the settings
+// cannot be converted, for example if a host proxy is not a valid URL.
+func (c *TransportConfig) Transport() (*Transport, error) {
+	t := &Transport{
+		DisableKeepAlives:      c.DisableKeepAlives,
+		DisableCompression:     c.DisableCompression,
+		MaxIdleConns:           c.MaxIdleConns,
+		MaxIdleConnsPerHost:    c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        c.MaxConnsPerHost,
+		IdleConnTimeout:        time.Duration(c.IdleConnTimeout),
+		MinIdleConns:           c.MinIdleConns,
+		MaxConnLifetime:        time.Duration(c.MaxConnLifetime),
+		MaxConnLifetimeJitter:  time.Duration(c.MaxConnLifetimeJitter),
+		DialTimeout:            time.Duration(c.DialTimeout),
+		DialKeepAlive:          time.Duration(c.DialKeepAlive),
+		DialFallbackDelay:      time.Duration(c.DialFallbackDelay),
+		DialAddressTimeout:     time.Duration(c.DialAddressTimeout),
+		TLSHandshakeTimeout:    time.Duration(c.TLSHandshakeTimeout),
+		ResponseHeaderTimeout:  time.Duration(c.ResponseHeaderTimeout),
+		ExpectContinueTimeout:  time.Duration(c.ExpectContinueTimeout),
+		MaxResponseHeaderBytes: c.MaxResponseHeaderBytes,
+		WriteBufferSize:        c.WriteBufferSize,
+		ReadBufferSize:         c.ReadBufferSize,
+		ForceAttemptHTTP2:      c.ForceAttemptHTTP2,
+		H2C:                    c.H2C,
+		HTTP2ReadIdleTimeout:   time.Duration(c.HTTP2ReadIdleTimeout),
+		HTTP2PingTimeout:       time.Duration(c.HTTP2PingTimeout),
+		RequestTimeout:         time.Duration(c.RequestTimeout),
+		RetryAfterTimeout:      c.RetryAfterTimeout,
+		MaxTries:               c.MaxTries,
+		MaxCoalescedBodySize:   c.MaxCoalescedBodySize,
+		MaxConcurrentRequests:  c.MaxConcurrentRequests,
+		MaxQueuedRequests:      c.MaxQueuedRequests,
+		TLSSessionCacheSize:    c.TLSSessionCacheSize,
+		Pins:                   c.Pins,
+		ProxyUsername:          c.ProxyUsername,
+		ProxyPassword:          c.ProxyPassword,
+		SOCKS5Proxy:            c.SOCKS5Proxy,
+		Resolve:                c.Resolve,
+		UnixSockets:            c.UnixSockets,
+		Fallbacks:              c.Fallbacks,
+	}
+	if c.Coalesce {
+		t.CoalesceKey = CoalesceByURL
+	}
+	if c.InsecureSkipVerify {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	t.TLSOptions = &TLSOptions{
+		CAFile:       c.CAFile,
+		CertFile:     c.ClientCert,
+		KeyFile:      c.ClientKey,
+		MinVersion:   c.TLSMinVersion,
+		ServerName:   c.TLSServerName,
+		CipherPolicy: c.TLSCipherPolicy,
+	}
+	t.ProxySelector = &ProxySelector{
+		HTTPProxy:       c.HTTPProxy,
+		HTTPSProxy:      c.HTTPSProxy,
+		NoProxy:         c.NoProxy,
+		Rules:           c.ProxyRules,
+		FromEnvironment: c.ProxyFromEnv,
+	}
+	if len(c.ProxyConnectHeader) != 0 {
+		t.ProxyConnectHeader = make(http.Header, len(c.ProxyConnectHeader))
+		for k, v := range c.ProxyConnectHeader {
+			t.ProxyConnectHeader.Set(k, v)
+		}
+	}
+	if c.DNSCache != nil {
+		t.DNSCache = &DNSCache{
+			TTL:         time.Duration(c.DNSCache.TTL),
+			NegativeTTL: time.Duration(c.DNSCache.NegativeTTL),
+			MaxStale:    time.Duration(c.DNSCache.MaxStale),
+		}
+	}
+	if h := c.HealthCheck; h != nil {
+		t.HealthCheck = &HealthCheck{
+			Path:                h.Path,
+			Interval:            time.Duration(h.Interval),
+			ConsecutiveFailures: h.ConsecutiveFailures,
+			ErrorRate:           h.ErrorRate,
+			MinRequests:         h.MinRequests,
+			Window:              time.Duration(h.Window),
+			BaseEjectionTime:    time.Duration(h.BaseEjectionTime),
+			MaxEjectionTime:     time.Duration(h.MaxEjectionTime),
+		}
+	}
+	if len(c.Hosts) != 0 {
+		t.Hosts = make(map[string]*HostSettings, len(c.Hosts))
+		for pattern, h := range c.Hosts {
+			s := &HostSettings{
+				RequestTimeout:        time.Duration(h.RequestTimeout),
+				ResponseHeaderTimeout: time.Duration(h.ResponseHeaderTimeout),
+				DialTimeout:           time.Duration(h.DialTimeout),
+				TLSHandshakeTimeout:   time.Duration(h.TLSHandshakeTimeout),
+				IdleConnTimeout:       time.Duration(h.IdleConnTimeout),
+				MaxTries:              h.MaxTries,
+				MaxIdleConnsPerHost:   h.MaxIdleConnsPerHost,
+				MaxConnsPerHost:       h.MaxConnsPerHost,
+			}
+			if h.Proxy != "" {
+				u, err := parseProxyURL(h.Proxy)
+				if err != nil {
+					return nil, err
+				}
+				s.Proxy = func(*http.Request) (*url.URL, error) { return u, nil }
+			}
+			t.Hosts[pattern] = s
+		}
+	}
+	return t, nil
+}
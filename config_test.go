@@ -0,0 +1,155 @@
+package httpcontrol_test
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+const configJSON = `{
+	"request_timeout": "30s",
+	"max_tries": 2,
+	"retry_after_timeout": true,
+	"max_idle_conns_per_host": 10,
+	"insecure_skip_verify": true,
+	"tls_min_version": "1.2",
+	"http_proxy": "proxy:3128",
+	"proxy_password": "1234",
+	"proxy_rules": [
+		{"pattern": "*.internal", "proxy": "direct"},
+		{"pattern": "partner.com", "proxy": "http://partner-proxy:8080"}
+	],
+	"proxy_connect_header": {"X-Tenant": "a"},
+	"pins": {"example.com": ["hash1", "hash2"]},
+	"resolve": {"example.com:443": "10.0.0.1"},
+	"dns_cache": {"ttl": "1m"},
+	"health_check": {"path": "/health", "error_rate": 0.5},
+	"hosts": {
+		"analytics.example.com": {"request_timeout": "2m", "proxy": "direct"}
+	}
+}`
+
+const configYAML = `
+# Client settings.
+request_timeout: 30s
+max_tries: 2
+retry_after_timeout: true
+max_idle_conns_per_host: 10
+insecure_skip_verify: true
+tls_min_version: 1.2
+http_proxy: proxy:3128
+proxy_password: 1234
+proxy_rules:
+  - pattern: "*.internal"
+    proxy: direct
+  - pattern: partner.com
+    proxy: http://partner-proxy:8080 # with a comment
+proxy_connect_header:
+  X-Tenant: a
+pins:
+  example.com: [hash1, hash2]
+resolve:
+  "example.com:443": 10.0.0.1
+dns_cache:
+  ttl: 1m
+health_check:
+  path: /health
+  error_rate: 0.5
+hosts:
+  analytics.example.com:
+    request_timeout: 2m
+    proxy: direct
+`
+
+func TestLoadConfigJSON(t *testing.T) {
+	c, err := httpcontrol.LoadConfigJSON(strings.NewReader(configJSON))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, c.RequestTimeout, httpcontrol.Duration(30*time.Second))
+	ensure.DeepEqual(t, c.ProxyRules[1], httpcontrol.ProxyRule{
+		Pattern: "partner.com",
+		Proxy:   "http://partner-proxy:8080",
+	})
+
+	tr, err := c.Transport()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, tr.RequestTimeout, 30*time.Second)
+	ensure.DeepEqual(t, tr.MaxTries, uint(2))
+	ensure.True(t, tr.RetryAfterTimeout)
+	ensure.DeepEqual(t, tr.MaxIdleConnsPerHost, 10)
+	ensure.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+	ensure.DeepEqual(t, tr.ProxyConnectHeader, http.Header{"X-Tenant": {"a"}})
+	ensure.DeepEqual(t, tr.Pins, map[string][]string{"example.com": {"hash1", "hash2"}})
+	ensure.DeepEqual(t, tr.DNSCache.TTL, time.Minute)
+	ensure.DeepEqual(t, tr.HealthCheck.ErrorRate, 0.5)
+	ensure.DeepEqual(t, tr.Hosts["analytics.example.com"].RequestTimeout, 2*time.Minute)
+
+	u, _ := url.Parse("http://example.com/")
+	proxy, err := tr.ProxySelector.Proxy(&http.Request{URL: u})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, proxy.String(), "http://proxy:3128")
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	fromJSON, err := httpcontrol.LoadConfigJSON(strings.NewReader(configJSON))
+	ensure.Nil(t, err)
+	fromYAML, err := httpcontrol.LoadConfigYAML(strings.NewReader(configYAML))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, fromYAML, fromJSON)
+	ensure.DeepEqual(t, fromYAML.TLSMinVersion, "1.2")
+	ensure.DeepEqual(t, fromYAML.ProxyPassword, "1234")
+	ensure.DeepEqual(t, fromYAML.HealthCheck.ErrorRate, 0.5)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	cases := map[string]string{
+		`{"request_timeout": 30}`:     "invalid duration",
+		`{"request_timeout": "30"}`:   "missing unit",
+		`{"unknown": true}`:           "unknown field",
+		`{"max_tries": "two"}`:        "cannot unmarshal",
+		"request_timeout: 1s\n  x: 1": "unexpected indentation",
+	}
+	for doc, msg := range cases {
+		var err error
+		if strings.HasPrefix(doc, "{") {
+			_, err = httpcontrol.LoadConfigJSON(strings.NewReader(doc))
+		} else {
+			_, err = httpcontrol.LoadConfigYAML(strings.NewReader(doc))
+		}
+		ensure.Err(t, err, regexp.MustCompile(msg))
+	}
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	t.Setenv("HTTPCLIENT_REQUEST_TIMEOUT", "5s")
+	t.Setenv("HTTPCLIENT_DISABLE_KEEPALIVES", "true")
+	t.Setenv("HTTPCLIENT_MAX_TRIES", "4")
+	t.Setenv("HTTPCLIENT_FALLBACKS", "example.com=a.example.com,example.com=b.example.com")
+	t.Setenv("HTTPCLIENT_PROXY_RULES", "*.internal=direct")
+	t.Setenv("HTTPCLIENT_HEALTH_CHECK_CONSECUTIVE_FAILURES", "3")
+	c, err := httpcontrol.LoadConfigJSON(strings.NewReader(configJSON))
+	ensure.Nil(t, err)
+	ensure.Nil(t, c.ApplyEnv("HTTPCLIENT_"))
+	ensure.DeepEqual(t, c.RequestTimeout, httpcontrol.Duration(5*time.Second))
+	ensure.True(t, c.DisableKeepAlives)
+	ensure.DeepEqual(t, c.MaxTries, uint(4))
+	ensure.DeepEqual(t, c.Fallbacks, map[string][]string{
+		"example.com": {"a.example.com", "b.example.com"},
+	})
+	ensure.DeepEqual(t, c.ProxyRules, []httpcontrol.ProxyRule{{Pattern: "*.internal", Proxy: "direct"}})
+	ensure.DeepEqual(t, c.HealthCheck.ConsecutiveFailures, uint(3))
+	ensure.DeepEqual(t, c.HealthCheck.Path, "/health")
+	ensure.True(t, c.DNSCache != nil)
+
+	c = new(httpcontrol.TransportConfig)
+	ensure.Nil(t, c.ApplyEnv("OTHER_"))
+	ensure.True(t, c.HealthCheck == nil)
+
+	t.Setenv("HTTPCLIENT_MAX_TRIES", "many")
+	ensure.Err(t, c.ApplyEnv("HTTPCLIENT_"), regexp.MustCompile("invalid HTTPCLIENT_MAX_TRIES"))
+}
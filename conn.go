@@ -0,0 +1,397 @@
+package httpcontrol
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HostStats are the connection statistics for a single host, as returned by
+// Transport.Snapshot.
+type HostStats struct {
+	// Connections currently open, and how many of those are in use or idle.
+	Active int `json:"active"`
+	Idle   int `json:"idle"`
+	Total  int `json:"total"`
+
+	// Counters since the Transport was started.
+	Dials        uint64 `json:"dials"`
+	DialFailures uint64 `json:"dial_failures"`
+	Reuses       uint64 `json:"reuses"`
+}
+
+// connPool keeps the HostStats for the connections created by a Transport.
+type connPool struct {
+	mu    sync.Mutex
+	hosts map[string]*HostStats
+}
+
+// host returns the stats for the given address. Must be called with the lock
+// held.
+func (p *connPool) host(address string) *HostStats {
+	if p.hosts == nil {
+		p.hosts = make(map[string]*HostStats)
+	}
+	h := p.hosts[address]
+	if h == nil {
+		h = new(HostStats)
+		p.hosts[address] = h
+	}
+	return h
+}
+
+func (p *connPool) update(address string, f func(*HostStats)) {
+	p.mu.Lock()
+	f(p.host(address))
+	p.mu.Unlock()
+}
+
+func (p *connPool) snapshot() map[string]HostStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hosts := make(map[string]HostStats, len(p.hosts))
+	for address, h := range p.hosts {
+		s := *h
+		s.Idle = s.Total - s.Active
+		hosts[address] = s
+	}
+	return hosts
+}
+
+// Snapshot returns the connection statistics of the Transport keyed by the
+// dialed address. Only connections created by the Transport's dialer are
+// accounted for.
+func (t *Transport) Snapshot() map[string]HostStats {
+	t.startOnce.Do(t.start)
+	hosts := t.pool.snapshot()
+	t.derived(func(d *Transport) {
+		for address, s := range d.pool.snapshot() {
+			h := hosts[address]
+			h.Active += s.Active
+			h.Idle += s.Idle
+			h.Total += s.Total
+			h.Dials += s.Dials
+			h.DialFailures += s.DialFailures
+			h.Reuses += s.Reuses
+			hosts[address] = h
+		}
+	})
+	return hosts
+}
+
+// trackedConn wraps the connections created by the Transport so that it can
+// follow when they are in use, and retire them once they get too old.
+type trackedConn struct {
+	net.Conn
+	pool    *connPool
+	address string
+	proxy   string
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	closed  bool
+	timer   *time.Timer
+}
+
+type dialInfoKey struct{}
+
+// dialInfo collects what happened while dialing a connection for a request,
+// to be reported in its Stats. The http.Transport may hand a connection dialed
+// for one request to another, so this is best effort.
+type dialInfo struct {
+	mu  sync.Mutex
+	dns struct {
+		Lookup, Hit, Stale bool
+	}
+	remoteAddr string
+	proxy      string
+	tls        struct {
+		Handshake            time.Duration
+		Resumed              bool
+		Version, CipherSuite uint16
+	}
+}
+
+func dialInfoFrom(ctx context.Context) *dialInfo {
+	info, _ := ctx.Value(dialInfoKey{}).(*dialInfo)
+	return info
+}
+
+func (i *dialInfo) setDNS(hit, stale bool) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.dns.Lookup = true
+	i.dns.Hit = hit
+	i.dns.Stale = stale
+}
+
+func (i *dialInfo) setConn(addr net.Addr, proxy string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remoteAddr = addr.String()
+	if proxy != "" {
+		i.proxy = proxy
+	}
+}
+
+func (i *dialInfo) setProxy(proxy string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.proxy = proxy
+}
+
+func (i *dialInfo) setTLS(state tls.ConnectionState, handshake time.Duration) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.tls.Handshake = handshake
+	i.tls.Resumed = state.DidResume
+	i.tls.Version = state.Version
+	i.tls.CipherSuite = state.CipherSuite
+}
+
+// fill copies the collected information into the stats.
+func (i *dialInfo) fill(s *Stats) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s.DNS = i.dns
+	s.RemoteAddr = i.remoteAddr
+	s.Proxy = i.proxy
+	s.TLS = i.tls
+}
+
+// dial dials using the configured Dial function, or the default dialer, and
+// wraps the resulting connection.
+func (t *Transport) dial(ctx context.Context, network, address string) (*trackedConn, error) {
+	dialNetwork, dialAddress := network, address
+	path, unix := t.unixSocket(address)
+	if unix {
+		dialNetwork, dialAddress = "unix", path
+	} else if override, ok := t.resolve(address); ok {
+		dialAddress = override
+	}
+	var conn net.Conn
+	var err error
+	var proxy string
+	switch {
+	case t.socks != nil && !unix:
+		proxy = t.socks.address
+		conn, err = t.dialSOCKS5(ctx, dialNetwork, dialAddress)
+	case t.Dial != nil:
+		conn, err = t.Dial(dialNetwork, dialAddress)
+	default:
+		conn, err = t.defaultDial(ctx, dialNetwork, dialAddress)
+	}
+	t.pool.update(address, func(h *HostStats) {
+		h.Dials++
+		if err != nil {
+			h.DialFailures++
+		} else {
+			h.Total++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := t.track(conn, address)
+	c.proxy = proxy
+	return c, nil
+}
+
+// dialTLS dials and completes the TLS handshake, within the
+// TLSHandshakeTimeout if one is set.
+func (t *Transport) dialTLS(ctx context.Context, network, address string) (*tls.Conn, error) {
+	conn, err := t.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	config := t.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if len(t.Pins) != 0 {
+		var next func(tls.ConnectionState) error
+		if t.TLSClientConfig != nil {
+			next = t.TLSClientConfig.VerifyConnection
+		}
+		config.VerifyConnection = t.verifyPins(host, next)
+	}
+	if t.TLSHandshakeTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, config)
+	start := time.Now()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	dialInfoFrom(ctx).setTLS(tlsConn.ConnectionState(), time.Since(start))
+	return tlsConn, nil
+}
+
+// dialContext is used by the http.Transport for plain connections. Warmed up
+// connections are handed out first.
+func (t *Transport) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if conn := t.warmer.take(warmKey{address: address}, t.IdleConnTimeout); conn != nil {
+		return conn, nil
+	}
+	conn, err := t.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialTLSContext is used by the http.Transport for TLS connections that do not
+// go through a proxy. Warmed up connections are handed out first.
+func (t *Transport) dialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if conn := t.warmer.take(warmKey{tls: true, address: address}, t.IdleConnTimeout); conn != nil {
+		return conn, nil
+	}
+	conn, err := t.dialTLS(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *Transport) track(conn net.Conn, address string) *trackedConn {
+	c := &trackedConn{Conn: conn, pool: &t.pool, address: address}
+	if t.MaxConnLifetime != 0 {
+		lifetime := t.MaxConnLifetime
+		if t.MaxConnLifetimeJitter > 0 {
+			lifetime += time.Duration(rand.Int63n(int64(t.MaxConnLifetimeJitter)))
+		}
+		c.timer = time.AfterFunc(lifetime, c.retire)
+	}
+	return c
+}
+
+// trackedConnFrom returns the trackedConn underlying a connection handed out
+// by the http.Transport, if any.
+func trackedConnFrom(conn net.Conn) *trackedConn {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	c, _ := conn.(*trackedConn)
+	return c
+}
+
+// connTrace returns a context that accounts for the connection used for a
+// request and records its address in info. The caller must call release, if
+// set, once the request is done.
+func connTrace(ctx context.Context, release *func(), info *dialInfo) context.Context {
+	var handshakeStart time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		// Only called for TLS connections through a proxy, dialTLS records
+		// the other handshakes.
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				info.setTLS(state, time.Since(handshakeStart))
+			}
+		},
+		GotConn: func(got httptrace.GotConnInfo) {
+			c := trackedConnFrom(got.Conn)
+			var proxy string
+			if c != nil {
+				proxy = c.proxy
+			}
+			info.setConn(got.Conn.RemoteAddr(), proxy)
+			if c != nil {
+				// The request is retried on another connection if a reused
+				// one turned out to be broken.
+				if *release != nil {
+					(*release)()
+				}
+				*release = c.acquire(got.Reused)
+			}
+		},
+	})
+}
+
+// acquire marks the start of a request on the connection, and returns the
+// function marking its end. The function may be called more than once.
+func (c *trackedConn) acquire(reused bool) func() {
+	var once sync.Once
+	release := func() { once.Do(c.release) }
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active++
+	c.pool.update(c.address, func(h *HostStats) {
+		if reused {
+			h.Reuses++
+		}
+		if c.active == 1 && !c.closed {
+			h.Active++
+		}
+	})
+	return release
+}
+
+// release marks the end of a request on the connection. Retired connections
+// are closed once they are no longer in use.
+func (c *trackedConn) release() {
+	c.mu.Lock()
+	c.active--
+	if c.active == 0 && !c.closed {
+		c.pool.update(c.address, func(h *HostStats) { h.Active-- })
+	}
+	retire := c.retired && c.active == 0
+	c.mu.Unlock()
+	if retire {
+		c.Close()
+	}
+}
+
+// retire closes the connection if it is idle, or marks it to be closed once
+// the requests using it are done.
+func (c *trackedConn) retire() {
+	c.mu.Lock()
+	c.retired = true
+	idle := c.active == 0
+	c.mu.Unlock()
+	if idle {
+		c.Close()
+	}
+}
+
+func (c *trackedConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.pool.update(c.address, func(h *HostStats) {
+		h.Total--
+		if c.active > 0 {
+			h.Active--
+		}
+	})
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,245 @@
+package httpcontrol_test
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func countingServer(conns *int32) *httptest.Server {
+	server := httptest.NewUnstartedServer(sleepHandler(0))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	server.Start()
+	return server
+}
+
+func TestMaxConnLifetime(t *testing.T) {
+	t.Parallel()
+	var conns int32
+	server := countingServer(&conns)
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		MaxConnLifetime: 50 * time.Millisecond,
+	}
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		assertResponse(res, t)
+	}
+	ensure.DeepEqual(t, atomic.LoadInt32(&conns), int32(1))
+
+	time.Sleep(100 * time.Millisecond)
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.DeepEqual(t, atomic.LoadInt32(&conns), int32(2))
+}
+
+func TestMaxConnLifetimeWaitsForActiveRequest(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(100 * time.Millisecond))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		MaxConnLifetime: 10 * time.Millisecond,
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(0))
+	defer server.Close()
+	dead := httptest.NewServer(sleepHandler(0))
+	dead.Close()
+	transport := &httpcontrol.Transport{}
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	host := server.Listener.Addr().String()
+	ensure.DeepEqual(t, transport.Snapshot()[host], httpcontrol.HostStats{
+		Active: 1,
+		Total:  1,
+		Dials:  1,
+	})
+	assertResponse(res, t)
+
+	res, err = client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	_, err = client.Get(dead.URL)
+	ensure.NotNil(t, err)
+
+	ensure.DeepEqual(t, transport.Snapshot(), map[string]httpcontrol.HostStats{
+		host: {
+			Idle:   1,
+			Total:  1,
+			Dials:  1,
+			Reuses: 1,
+		},
+		dead.Listener.Addr().String(): {
+			Dials:        1,
+			DialFailures: 1,
+		},
+	})
+}
+
+func TestSnapshotDoubleClose(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(0))
+	defer server.Close()
+	transport := &httpcontrol.Transport{}
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.Nil(t, res.Body.Close())
+
+	res, err = client.Get(server.URL)
+	ensure.Nil(t, err)
+	host := server.Listener.Addr().String()
+	ensure.DeepEqual(t, transport.Snapshot()[host], httpcontrol.HostStats{
+		Active: 1,
+		Total:  1,
+		Dials:  1,
+		Reuses: 1,
+	})
+	assertResponse(res, t)
+}
+
+func TestDNSCacheStats(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(0))
+	defer server.Close()
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		DisableKeepAlives: true,
+		DNSCache:          &httpcontrol.DNSCache{},
+		Stats:             func(s *httpcontrol.Stats) { stats = *s },
+	}
+	client := &http.Client{Transport: transport}
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	u := "http://localhost:" + port + "/"
+
+	res, err := client.Get(u)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.True(t, stats.DNS.Lookup)
+	ensure.False(t, stats.DNS.Hit)
+
+	res, err = client.Get(u)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.True(t, stats.DNS.Hit)
+}
+
+func TestRemoteAddrStats(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(0))
+	defer server.Close()
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		Stats: func(s *httpcontrol.Stats) { stats = *s },
+	}
+	client := &http.Client{Transport: transport}
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	res, err := client.Get("http://localhost:" + port + "/")
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.DeepEqual(t, stats.RemoteAddr, server.Listener.Addr().String())
+}
+
+func TestUnixSocket(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "server.sock")
+	listener, err := net.Listen("unix", path)
+	ensure.Nil(t, err)
+	server := httptest.NewUnstartedServer(sleepHandler(0))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		UnixSockets: map[string]string{"sidecar": path},
+		Stats:       func(s *httpcontrol.Stats) { stats = *s },
+	}
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get("http://sidecar/")
+		ensure.Nil(t, err)
+		assertResponse(res, t)
+	}
+	ensure.DeepEqual(t, stats.RemoteAddr, path)
+	ensure.DeepEqual(t, transport.Snapshot()["sidecar:80"], httpcontrol.HostStats{
+		Idle:   1,
+		Total:  1,
+		Dials:  1,
+		Reuses: 1,
+	})
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+	var host, serverName string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, serverName = r.Host, r.TLS.ServerName
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	transport := &httpcontrol.Transport{
+		TLSClientConfig: server.Client().Transport.(*http.Transport).TLSClientConfig,
+		Resolve:         map[string]string{"example.com:" + port: "127.0.0.1"},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get("https://example.com:" + port + "/")
+	ensure.Nil(t, err)
+	res.Body.Close()
+	ensure.DeepEqual(t, res.StatusCode, http.StatusOK)
+	ensure.DeepEqual(t, host, "example.com:"+port)
+	ensure.DeepEqual(t, serverName, "example.com")
+}
+
+func TestTLSStats(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(sleepHandler(0))
+	defer server.Close()
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		TLSClientConfig:   server.Client().Transport.(*http.Transport).TLSClientConfig,
+		DisableKeepAlives: true,
+		Stats:             func(s *httpcontrol.Stats) { stats = *s },
+	}
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.False(t, stats.TLS.Resumed)
+	ensure.True(t, stats.TLS.Handshake > 0)
+	ensure.DeepEqual(t, stats.TLS.Version, uint16(tls.VersionTLS13))
+	ensure.DeepEqual(t, stats.TLS.CipherSuite, res.TLS.CipherSuite)
+
+	res, err = client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.True(t, stats.TLS.Resumed)
+}
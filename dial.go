@@ -0,0 +1,219 @@
+package httpcontrol
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const defaultDialFallbackDelay = 300 * time.Millisecond
+
+// defaultDial resolves the host and races connection attempts to the
+// resulting addresses, as configured by DialFallbackDelay and
+// DialAddressTimeout. The whole dial is limited by DialTimeout.
+func (t *Transport) defaultDial(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{KeepAlive: t.DialKeepAlive}
+	if t.DialTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.DialTimeout)
+		defer cancel()
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return t.dialAddress(ctx, dialer, network, address)
+	}
+
+	var addrs []string
+	if t.DNSCache != nil {
+		addrs, err = t.DNSCache.lookup(ctx, host, dialInfoFrom(ctx))
+	} else {
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	addrs = interleave(filterNetwork(network, addrs))
+	if len(addrs) == 0 {
+		return nil, &net.OpError{
+			Op:  "dial",
+			Net: network,
+			Err: &net.AddrError{Err: "no suitable address found", Addr: host},
+		}
+	}
+	for i, addr := range addrs {
+		addrs[i] = net.JoinHostPort(addr, port)
+	}
+	return t.dialParallel(ctx, dialer, network, addrs)
+}
+
+// unixSocket returns the path of the Unix domain socket the address is mapped
+// to in UnixSockets, matching it with its port first and then without.
+func (t *Transport) unixSocket(address string) (string, bool) {
+	if len(t.UnixSockets) == 0 {
+		return "", false
+	}
+	if path, ok := t.UnixSockets[address]; ok {
+		return path, true
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", false
+	}
+	path, ok := t.UnixSockets[host]
+	return path, ok
+}
+
+// resolve returns the address the Resolve map overrides the address with. An
+// override without a port uses the port of the address.
+func (t *Transport) resolve(address string) (string, bool) {
+	override, ok := t.Resolve[address]
+	if !ok {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override, true
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", false
+	}
+	return net.JoinHostPort(override, port), true
+}
+
+// dialAddress makes a single connection attempt, limited by the
+// DialAddressTimeout.
+func (t *Transport) dialAddress(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if t.DialAddressTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.DialAddressTimeout)
+		defer cancel()
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialParallel starts a connection attempt to each address in turn, moving on
+// to the next one when the previous attempt failed or the DialFallbackDelay
+// elapsed. The first connection established wins and the others are closed.
+func (t *Transport) dialParallel(ctx context.Context, dialer *net.Dialer, network string, addrs []string) (net.Conn, error) {
+	delay := t.DialFallbackDelay
+	if delay == 0 {
+		delay = defaultDialFallbackDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(addrs))
+	pending, next := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := t.dialAddress(ctx, dialer, network, addr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	// closeLosers closes the connections of the attempts still pending once
+	// they complete.
+	closeLosers := func() {
+		go func(pending int) {
+			for i := 0; i < pending; i++ {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(pending)
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		var fallback <-chan time.Time
+		var timer *time.Timer
+		if next < len(addrs) && delay > 0 {
+			timer = time.NewTimer(delay)
+			fallback = timer.C
+		}
+		select {
+		case r := <-results:
+			if timer != nil {
+				timer.Stop()
+			}
+			pending--
+			if r.err == nil {
+				cancel()
+				closeLosers()
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-fallback:
+			start()
+		}
+	}
+	return nil, firstErr
+}
+
+// filterNetwork drops addresses that cannot be used with the network.
+func filterNetwork(network string, addrs []string) []string {
+	if network != "tcp4" && network != "tcp6" {
+		return addrs
+	}
+	var filtered []string
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (network == "tcp4") {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// interleave orders the addresses alternating between the IPv4 and IPv6
+// families, starting with the family of the first address, and otherwise
+// preserving their order.
+func interleave(addrs []string) []string {
+	if len(addrs) < 2 {
+		return addrs
+	}
+	var first, second []string
+	firstIs4 := isIPv4(addrs[0])
+	for _, addr := range addrs {
+		if isIPv4(addr) == firstIs4 {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	ordered := make([]string, 0, len(addrs))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			ordered = append(ordered, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			ordered = append(ordered, second[0])
+			second = second[1:]
+		}
+	}
+	return ordered
+}
+
+func isIPv4(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() != nil
+}
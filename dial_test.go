@@ -0,0 +1,54 @@
+package httpcontrol
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+func TestInterleave(t *testing.T) {
+	ensure.DeepEqual(t,
+		interleave([]string{"::1", "::2", "10.0.0.1", "10.0.0.2", "10.0.0.3"}),
+		[]string{"::1", "10.0.0.1", "::2", "10.0.0.2", "10.0.0.3"})
+	ensure.DeepEqual(t,
+		filterNetwork("tcp4", []string{"::1", "10.0.0.1"}),
+		[]string{"10.0.0.1"})
+}
+
+func TestDialParallelSkipsBlackholedAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ensure.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	tr := &Transport{DialFallbackDelay: 10 * time.Millisecond}
+	// 192.0.2.0/24 is reserved for documentation and never answers.
+	addrs := []string{"192.0.2.1:80", l.Addr().String()}
+	start := time.Now()
+	conn, err := tr.dialParallel(context.Background(), &net.Dialer{}, "tcp", addrs)
+	ensure.Nil(t, err)
+	defer conn.Close()
+	ensure.DeepEqual(t, conn.RemoteAddr().String(), l.Addr().String())
+	ensure.True(t, time.Since(start) < time.Second)
+}
+
+func TestDialAddressTimeout(t *testing.T) {
+	tr := &Transport{
+		DialFallbackDelay:  -1,
+		DialAddressTimeout: 10 * time.Millisecond,
+	}
+	_, err := tr.dialParallel(context.Background(), &net.Dialer{}, "tcp",
+		[]string{"192.0.2.1:80", "192.0.2.2:80"})
+	ensure.NotNil(t, err)
+}
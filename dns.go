@@ -0,0 +1,238 @@
+package httpcontrol
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DNSCache is an in-process cache of host name lookups used by the
+// Transport's default dialer. The zero value is ready to use. A DNSCache may
+// be shared between Transports, but must not be copied after first use.
+//
+// Entries that are used during the last quarter of their TTL are refreshed in
+// the background, so that busy hosts do not wait for lookups. Each lookup
+// rotates the order of the returned addresses to spread connections across
+// them. Concurrent lookups of a host share a single query, which is not
+// canceled when the callers give up. Hosts that are no longer looked up are
+// forgotten once their entries can no longer be used, even as stale ones.
+type DNSCache struct {
+	// TTL is how long successful lookups are cached. The default is 1 minute.
+	TTL time.Duration
+
+	// NegativeTTL is how long failed lookups are cached. The default is 5
+	// seconds.
+	NegativeTTL time.Duration
+
+	// MaxStale is how long after expiring a successful lookup may still be
+	// used if looking the host up again fails. The default is 1 hour.
+	MaxStale time.Duration
+
+	// Resolver used for lookups. If nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	swept   time.Time
+}
+
+// dnsLookupTimeout limits the queries made independently of the callers.
+const dnsLookupTimeout = 10 * time.Second
+
+type dnsEntry struct {
+	pending    *dnsLookup
+	addrs      []string
+	expires    time.Time
+	err        error
+	errExpires time.Time
+	refreshing bool
+	next       int
+}
+
+// rotated returns the addresses starting at the next one in turn. Must be
+// called with the lock held.
+func (e *dnsEntry) rotated() []string {
+	addrs := make([]string, 0, len(e.addrs))
+	start := e.next % len(e.addrs)
+	addrs = append(addrs, e.addrs[start:]...)
+	addrs = append(addrs, e.addrs[:start]...)
+	e.next++
+	return addrs
+}
+
+func (c *DNSCache) ttl() time.Duration {
+	if c.TTL == 0 {
+		return time.Minute
+	}
+	return c.TTL
+}
+
+func (c *DNSCache) negativeTTL() time.Duration {
+	if c.NegativeTTL == 0 {
+		return 5 * time.Second
+	}
+	return c.NegativeTTL
+}
+
+func (c *DNSCache) maxStale() time.Duration {
+	if c.MaxStale == 0 {
+		return time.Hour
+	}
+	return c.MaxStale
+}
+
+func (c *DNSCache) resolver() *net.Resolver {
+	if c.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.Resolver
+}
+
+// entry returns the entry for host. Must be called with the lock held.
+func (c *DNSCache) entry(host string) *dnsEntry {
+	if c.entries == nil {
+		c.entries = make(map[string]*dnsEntry)
+	}
+	e := c.entries[host]
+	if e == nil {
+		e = new(dnsEntry)
+		c.entries[host] = e
+	}
+	return e
+}
+
+func (c *DNSCache) resolve(ctx context.Context, host string) ([]string, error) {
+	ips, err := c.resolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs, nil
+}
+
+// stale returns true if the entry can be used after a failed lookup. Must be
+// called with the lock held.
+func (c *DNSCache) stale(e *dnsEntry, now time.Time) bool {
+	return len(e.addrs) > 0 && now.Before(e.expires.Add(c.maxStale()))
+}
+
+// sweep forgets the entries that are neither fresh, stale nor negative, and
+// have no lookup in progress. Entries of hosts that are still looked up are
+// kept current, so these are the ones of hosts no longer in use. Must be
+// called with the lock held.
+func (c *DNSCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.ttl() {
+		return
+	}
+	c.swept = now
+	for host, e := range c.entries {
+		if e.pending == nil && !e.refreshing && !c.stale(e, now) && !now.Before(e.errExpires) {
+			delete(c.entries, host)
+		}
+	}
+}
+
+// lookup returns the addresses for host, recording how they were obtained in
+// info.
+func (c *DNSCache) lookup(ctx context.Context, host string, info *dialInfo) ([]string, error) {
+	now := time.Now()
+	c.mu.Lock()
+	c.sweep(now)
+	e := c.entry(host)
+	if e.err != nil && now.Before(e.errExpires) {
+		defer c.mu.Unlock()
+		if c.stale(e, now) {
+			info.setDNS(true, true)
+			return e.rotated(), nil
+		}
+		info.setDNS(true, false)
+		return nil, e.err
+	}
+	if len(e.addrs) > 0 && now.Before(e.expires) {
+		defer c.mu.Unlock()
+		if !e.refreshing && now.After(e.expires.Add(-c.ttl()/4)) {
+			e.refreshing = true
+			go c.refresh(host)
+		}
+		info.setDNS(true, false)
+		return e.rotated(), nil
+	}
+	if e.pending == nil {
+		e.pending = &dnsLookup{done: make(chan struct{})}
+		go c.query(host, e.pending)
+	}
+	pending := e.pending
+	c.mu.Unlock()
+
+	select {
+	case <-pending.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e = c.entry(host)
+	if pending.err != nil {
+		if c.stale(e, time.Now()) {
+			info.setDNS(true, true)
+			return e.rotated(), nil
+		}
+		info.setDNS(false, false)
+		return nil, pending.err
+	}
+	info.setDNS(false, false)
+	return e.rotated(), nil
+}
+
+// dnsLookup is a query shared by the callers looking up a host.
+type dnsLookup struct {
+	done chan struct{}
+	err  error
+}
+
+// query looks up host for the callers waiting on l, on a context of its own
+// so that a caller giving up does not fail the others or get cached.
+func (c *DNSCache) query(host string, l *dnsLookup) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	addrs, err := c.resolve(ctx, host)
+
+	c.mu.Lock()
+	now := time.Now()
+	e := c.entry(host)
+	e.pending = nil
+	if err != nil {
+		e.err = err
+		e.errExpires = now.Add(c.negativeTTL())
+	} else {
+		e.addrs = addrs
+		e.expires = now.Add(c.ttl())
+		e.err = nil
+	}
+	l.err = err
+	c.mu.Unlock()
+	close(l.done)
+}
+
+// refresh looks up host in the background before its entry expires. Failures
+// are ignored, the next lookup after the entry expires will retry.
+func (c *DNSCache) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	addrs, err := c.resolve(ctx, host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entry(host)
+	e.refreshing = false
+	if err == nil {
+		e.addrs = addrs
+		e.expires = time.Now().Add(c.ttl())
+		e.err = nil
+	}
+}
@@ -0,0 +1,90 @@
+package httpcontrol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+func failingResolver(lookups *int32) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(lookups, 1)
+			return nil, errors.New("resolver down")
+		},
+	}
+}
+
+func TestDNSCacheServesStale(t *testing.T) {
+	var lookups int32
+	c := &DNSCache{Resolver: failingResolver(&lookups)}
+	c.entries = map[string]*dnsEntry{
+		"svc.test": {
+			addrs:   []string{"10.0.0.1", "10.0.0.2"},
+			expires: time.Now().Add(-time.Minute),
+		},
+	}
+
+	info := new(dialInfo)
+	addrs, err := c.lookup(context.Background(), "svc.test", info)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, addrs, []string{"10.0.0.1", "10.0.0.2"})
+	ensure.True(t, info.dns.Stale)
+	ensure.True(t, lookups > 0)
+
+	addrs, err = c.lookup(context.Background(), "svc.test", info)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, addrs, []string{"10.0.0.2", "10.0.0.1"})
+}
+
+func TestDNSCacheNegative(t *testing.T) {
+	var lookups int32
+	c := &DNSCache{Resolver: failingResolver(&lookups)}
+	_, err := c.lookup(context.Background(), "svc.test", nil)
+	ensure.NotNil(t, err)
+	before := atomic.LoadInt32(&lookups)
+
+	info := new(dialInfo)
+	_, err = c.lookup(context.Background(), "svc.test", info)
+	ensure.NotNil(t, err)
+	ensure.DeepEqual(t, atomic.LoadInt32(&lookups), before)
+	ensure.True(t, info.dns.Hit)
+}
+
+func TestDNSCacheIgnoresCanceledCallers(t *testing.T) {
+	c := &DNSCache{Resolver: &net.Resolver{PreferGo: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.lookup(ctx, "localhost", nil)
+
+	addrs, err := c.lookup(context.Background(), "localhost", nil)
+	ensure.Nil(t, err)
+	ensure.True(t, len(addrs) > 0)
+}
+
+func TestDNSCacheSweep(t *testing.T) {
+	now := time.Now()
+	c := &DNSCache{MaxStale: time.Minute}
+	c.entries = map[string]*dnsEntry{
+		"fresh.test": {addrs: []string{"10.0.0.1"}, expires: now.Add(time.Minute)},
+		"stale.test": {addrs: []string{"10.0.0.2"}, expires: now.Add(-time.Second)},
+		"gone.test":  {addrs: []string{"10.0.0.3"}, expires: now.Add(-2 * time.Minute)},
+		"failed.test": {
+			err:        errors.New("resolver down"),
+			errExpires: now.Add(-time.Second),
+		},
+		"pending.test": {pending: &dnsLookup{done: make(chan struct{})}},
+	}
+	c.sweep(now)
+	_, fresh := c.entries["fresh.test"]
+	_, stale := c.entries["stale.test"]
+	_, pending := c.entries["pending.test"]
+	ensure.True(t, fresh && stale && pending)
+	ensure.DeepEqual(t, len(c.entries), 3)
+}
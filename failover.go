@@ -0,0 +1,89 @@
+package httpcontrol
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type failoverKey struct{}
+
+// failoverState is attached to requests that have been rewritten to use one
+// of the Fallbacks.
+type failoverState struct {
+	origin string // the host in the original URL
+	index  int    // the position of the current host in the Fallbacks
+}
+
+// isDialError returns true if the error happened while connecting, in which
+// case the request was never sent.
+func isDialError(err error) bool {
+	var operr *net.OpError
+	return errors.As(err, &operr) && operr.Op == "dial"
+}
+
+// isTLSError returns true if the error happened during the TLS handshake, in
+// which case the request was never sent.
+func isTLSError(err error) bool {
+	var (
+		recordErr  tls.RecordHeaderError
+		verifyErr  *tls.CertificateVerificationError
+		alertErr   tls.AlertError
+		authErr    x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &alertErr) || errors.As(err, &authErr) ||
+		errors.As(err, &hostErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "tls: ")
+}
+
+// failover returns a copy of req addressed to the next fallback host, or nil
+// if the error does not allow failing over or no fallback host is left.
+func (t *Transport) failover(req *http.Request, err error) *http.Request {
+	state, ok := req.Context().Value(failoverKey{}).(failoverState)
+	if !ok {
+		state = failoverState{origin: req.URL.Host, index: -1}
+	}
+	hosts := t.Fallbacks[state.origin]
+	state.index++
+	if state.index >= len(hosts) {
+		return nil
+	}
+
+	var pinErr *PinMismatchError
+	if errors.As(err, &pinErr) {
+		return nil
+	}
+	var ejected *HostEjectedError
+	unsent := isDialError(err) || isTLSError(err) || errors.As(err, &ejected)
+	if !unsent && !(req.Method == "GET" && t.shouldRetryError(err)) {
+		return nil
+	}
+
+	body := req.Body
+	if body != nil && body != http.NoBody {
+		if req.GetBody == nil {
+			return nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return nil
+		}
+	}
+
+	ctx := context.WithValue(req.Context(), failoverKey{}, state)
+	r := req.WithContext(ctx)
+	u := *req.URL
+	u.Host = hosts[state.index]
+	r.URL = &u
+	r.Host = ""
+	r.Body = body
+	return r
+}
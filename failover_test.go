@@ -0,0 +1,57 @@
+package httpcontrol_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestFailoverOnDialError(t *testing.T) {
+	t.Parallel()
+	dead := httptest.NewServer(sleepHandler(0))
+	dead.Close()
+	live := httptest.NewServer(sleepHandler(0))
+	defer live.Close()
+	deadHost := dead.Listener.Addr().String()
+	liveHost := live.Listener.Addr().String()
+
+	var stats []httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		Fallbacks: map[string][]string{
+			deadHost: {deadHost + "0", liveHost},
+		},
+		Stats: func(s *httpcontrol.Stats) { stats = append(stats, *s) },
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Post("http://"+deadHost+"/", "text/plain", strings.NewReader("hi"))
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+
+	ensure.DeepEqual(t, len(stats), 3)
+	ensure.NotNil(t, stats[0].Error)
+	ensure.True(t, stats[0].Retry.Pending)
+	ensure.DeepEqual(t, stats[0].Failover.To, "")
+	ensure.NotNil(t, stats[1].Error)
+	ensure.DeepEqual(t, stats[1].Failover.To, deadHost+"0")
+	ensure.Nil(t, stats[2].Error)
+	ensure.DeepEqual(t, stats[2].Retry.Count, uint(2))
+	ensure.DeepEqual(t, stats[2].Failover.From, deadHost)
+	ensure.DeepEqual(t, stats[2].Failover.To, liveHost)
+}
+
+func TestFailoverExhausted(t *testing.T) {
+	t.Parallel()
+	dead := httptest.NewServer(sleepHandler(0))
+	dead.Close()
+	deadHost := dead.Listener.Addr().String()
+	transport := &httpcontrol.Transport{
+		Fallbacks: map[string][]string{deadHost: {deadHost}},
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get("http://" + deadHost + "/")
+	ensure.NotNil(t, err)
+}
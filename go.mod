@@ -0,0 +1,11 @@
+module github.com/facebookgo/httpcontrol
+
+go 1.24
+
+require github.com/facebookgo/ensure v0.0.0-20200202191622-63f1cf65ac4c
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/facebookgo/stack v0.0.0-20160209184415-751773369052 // indirect
+	github.com/facebookgo/subset v0.0.0-20200203212716-c811ad88dec4 // indirect
+)
@@ -0,0 +1,141 @@
+package httpcontrol
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// HostSettings override the settings of a Transport for the hosts matching a
+// pattern in Transport.Hosts. Zero values keep the setting of the Transport.
+type HostSettings struct {
+	RequestTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	IdleConnTimeout       time.Duration
+	MaxTries              uint
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	TLSClientConfig       *tls.Config
+	Proxy                 func(*http.Request) (*url.URL, error)
+}
+
+// derive returns a started Transport with the settings of t overridden by s.
+// Only the derived Transport's inner transport and settings are used, requests
+// are still admitted, accounted for and shut down by t.
+func (t *Transport) derive(s *HostSettings) *Transport {
+	d := t.overlay(s)
+	d.startOnce.Do(d.start)
+	return d
+}
+
+// overlay returns a copy of the settings of t overridden by s.
+func (t *Transport) overlay(s *HostSettings) *Transport {
+	d := new(Transport)
+	src := reflect.ValueOf(t).Elem()
+	dst := reflect.ValueOf(d).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if dst.Field(i).CanSet() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	d.Hosts = nil
+	d.HealthCheck = nil
+	d.CoalesceKey = nil
+	d.MaxConcurrentRequests = 0
+
+	if s.RequestTimeout != 0 {
+		d.RequestTimeout = s.RequestTimeout
+	}
+	if s.ResponseHeaderTimeout != 0 {
+		d.ResponseHeaderTimeout = s.ResponseHeaderTimeout
+	}
+	if s.DialTimeout != 0 {
+		d.DialTimeout = s.DialTimeout
+	}
+	if s.TLSHandshakeTimeout != 0 {
+		d.TLSHandshakeTimeout = s.TLSHandshakeTimeout
+	}
+	if s.IdleConnTimeout != 0 {
+		d.IdleConnTimeout = s.IdleConnTimeout
+	}
+	if s.MaxTries != 0 {
+		d.MaxTries = s.MaxTries
+	}
+	if s.MaxIdleConnsPerHost != 0 {
+		d.MaxIdleConnsPerHost = s.MaxIdleConnsPerHost
+	}
+	if s.MaxConnsPerHost != 0 {
+		d.MaxConnsPerHost = s.MaxConnsPerHost
+	}
+	if s.TLSClientConfig != nil {
+		d.TLSClientConfig = s.TLSClientConfig
+	}
+	if s.Proxy != nil {
+		d.Proxy = s.Proxy
+	}
+	return d
+}
+
+// forHost returns the Transport whose settings apply to the host, which is t
+// itself unless the host matches one of the Hosts patterns. Patterns with a
+// port are matched first, then the host name, then the longest matching
+// "*." wildcard.
+func (t *Transport) forHost(host string) *Transport {
+	if len(t.hosts) == 0 {
+		return t
+	}
+	if d, ok := t.hosts[host]; ok {
+		return d
+	}
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if d, ok := t.hosts[name]; ok {
+		return d
+	}
+	var best *Transport
+	var bestLen int
+	for pattern, d := range t.hosts {
+		if !strings.HasPrefix(pattern, "*.") {
+			continue
+		}
+		suffix := pattern[1:]
+		if strings.HasSuffix(name, suffix) && len(suffix) > bestLen {
+			best, bestLen = d, len(suffix)
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return t
+}
+
+// matchHost returns true if the host, with its port, matches the pattern:
+// the host with or without its port, or a "*." wildcard.
+func matchHost(pattern, hostport string) bool {
+	if pattern == hostport {
+		return true
+	}
+	name, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		name = hostport
+	}
+	if pattern == name {
+		return true
+	}
+	return strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:])
+}
+
+// derived calls f with each of the Transports created for the Hosts.
+func (t *Transport) derived(f func(*Transport)) {
+	for _, d := range t.hosts {
+		f(d)
+	}
+}
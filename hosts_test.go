@@ -0,0 +1,35 @@
+package httpcontrol_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestHostSettings(t *testing.T) {
+	t.Parallel()
+	slow := httptest.NewServer(sleepHandler(100 * time.Millisecond))
+	defer slow.Close()
+	_, port, _ := net.SplitHostPort(slow.Listener.Addr().String())
+	transport := &httpcontrol.Transport{
+		RequestTimeout: 50 * time.Millisecond,
+		Hosts: map[string]*httpcontrol.HostSettings{
+			"localhost": {RequestTimeout: time.Second},
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get("http://localhost:" + port + "/")
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+
+	_, err = client.Get(slow.URL)
+	ensure.NotNil(t, err)
+
+	ensure.DeepEqual(t, transport.Snapshot()["localhost:"+port].Dials, uint64(1))
+}
@@ -0,0 +1,84 @@
+package httpcontrol_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func protoHandler() http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Proto", r.Proto)
+			w.Write(theAnswer)
+		})
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewUnstartedServer(protoHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	var proto string
+	transport := &httpcontrol.Transport{
+		TLSClientConfig:      &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2:    true,
+		HTTP2ReadIdleTimeout: time.Second,
+		Stats:                func(s *httpcontrol.Stats) { proto = s.Proto },
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, res.Header.Get("X-Proto"), "HTTP/2.0")
+	assertResponse(res, t)
+	ensure.DeepEqual(t, proto, "HTTP/2.0")
+}
+
+func TestH2C(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewUnstartedServer(protoHandler())
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	transport := &httpcontrol.Transport{H2C: true}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, res.Header.Get("X-Proto"), "HTTP/2.0")
+	assertResponse(res, t)
+}
+
+func TestH2CFallsBackToHTTP1OverTLS(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(protoHandler())
+	defer server.Close()
+
+	transport := &httpcontrol.Transport{
+		H2C:             true,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, res.Header.Get("X-Proto"), "HTTP/1.1")
+	assertResponse(res, t)
+
+	h2 := httptest.NewUnstartedServer(protoHandler())
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+	res, err = client.Get(h2.URL)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, res.Header.Get("X-Proto"), "HTTP/2.0")
+	assertResponse(res, t)
+}
@@ -2,6 +2,11 @@ package httpcontrol_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,18 +14,29 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/facebookgo/ensure"
-	"github.com/facebookgo/freeport"
 	"github.com/facebookgo/httpcontrol"
 )
 
 var theAnswer = []byte("42")
 
+// freePort returns a local port that nothing is listening on.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
 func sleepHandler(timeout time.Duration) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -186,14 +202,17 @@ func TestResponseTimeout(t *testing.T) {
 	if res != nil {
 		t.Fatal("was expecting nil response")
 	}
-	if !strings.Contains(err.Error(), "use of closed network connection") {
-		t.Fatalf("was expecting closed network connection related error, got %s", err)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("was expecting deadline exceeded error, got %s", err)
+	}
+	if uerr, ok := err.(*url.Error); !ok || !uerr.Timeout() {
+		t.Fatalf("was expecting timeout error, got %s", err)
 	}
 }
 
 func TestSafeRetry(t *testing.T) {
 	t.Parallel()
-	port, err := freeport.Get()
+	port, err := freePort()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -245,7 +264,7 @@ func TestSafeRetry(t *testing.T) {
 
 func TestSafeRetryAfterTimeout(t *testing.T) {
 	t.Parallel()
-	port, err := freeport.Get()
+	port, err := freePort()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -373,6 +392,128 @@ func TestFlag(t *testing.T) {
 	}
 }
 
+func TestFlagPoolSettings(t *testing.T) {
+	name := flagName()
+	c := httpcontrol.TransportFlag(name)
+	ensure.Nil(t, flag.Set(name+".max-idle-conns", "7"))
+	ensure.Nil(t, flag.Set(name+".idle-conn-timeout", "1m"))
+	ensure.Nil(t, flag.Set(name+".force-http2", "true"))
+	ensure.DeepEqual(t, c.MaxIdleConns, 7)
+	ensure.DeepEqual(t, c.IdleConnTimeout, time.Minute)
+	ensure.True(t, c.ForceAttemptHTTP2)
+	ensure.DeepEqual(t, c.TLSHandshakeTimeout, 10*time.Second)
+}
+
+func TestFlagResolve(t *testing.T) {
+	name := flagName()
+	c := httpcontrol.TransportFlag(name)
+	ensure.Nil(t, flag.Set(name+".resolve", "example.com:443:10.0.0.1"))
+	ensure.Nil(t, flag.Set(name+".resolve", "example.com:80:[::1]"))
+	ensure.NotNil(t, flag.Set(name+".resolve", "example.com:443"))
+	ensure.DeepEqual(t, c.Resolve, map[string]string{
+		"example.com:443": "10.0.0.1",
+		"example.com:80":  "::1",
+	})
+	ensure.DeepEqual(
+		t,
+		flag.Lookup(name+".resolve").Value.String(),
+		"example.com:443:10.0.0.1,example.com:80:[::1]",
+	)
+}
+
+func TestTransportFlagSet(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c := httpcontrol.TransportFlagSet(fs, "client")
+	ensure.Nil(t, fs.Parse([]string{
+		"-client.retry-after-timeout",
+		"-client.max-tries=2",
+		"-client.proxy-connect-header=X-Tenant: a",
+		"-client.pin=example.com=hash1",
+		"-client.pin=example.com=hash2",
+		"-client.unix-socket=sidecar=/run/sidecar.sock",
+		"-client.fallback=example.com=a.example.com,b.example.com",
+		"-client.coalesce",
+		"-client.dns-cache-ttl=30s",
+		"-client.outlier-consecutive-failures=5",
+	}))
+	ensure.True(t, flag.Lookup("client.max-tries") == nil)
+	ensure.True(t, c.RetryAfterTimeout)
+	ensure.DeepEqual(t, c.MaxTries, uint(2))
+	ensure.DeepEqual(t, c.ProxyConnectHeader, http.Header{"X-Tenant": {"a"}})
+	ensure.DeepEqual(t, c.Pins, map[string][]string{"example.com": {"hash1", "hash2"}})
+	ensure.DeepEqual(t, c.UnixSockets, map[string]string{"sidecar": "/run/sidecar.sock"})
+	ensure.DeepEqual(t, c.Fallbacks, map[string][]string{
+		"example.com": {"a.example.com", "b.example.com"},
+	})
+	ensure.NotNil(t, c.CoalesceKey)
+	ensure.DeepEqual(t, c.DNSCache.TTL, 30*time.Second)
+	ensure.DeepEqual(t, c.HealthCheck.ConsecutiveFailures, uint(5))
+	ensure.DeepEqual(t, c.DialTimeout, 2*time.Second)
+}
+
+func TestTransportFlagSetDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c := httpcontrol.TransportFlagSet(fs, "client")
+	ensure.Nil(t, fs.Parse(nil))
+	ensure.True(t, c.DNSCache == nil)
+	ensure.True(t, c.HealthCheck == nil)
+	ensure.True(t, c.CoalesceKey == nil)
+	ensure.Nil(t, c.Validate())
+
+	c.Proxy = http.ProxyFromEnvironment
+	ensure.Nil(t, c.Validate())
+}
+
+func TestFlagProxy(t *testing.T) {
+	name := flagName()
+	c := httpcontrol.TransportFlag(name)
+	ensure.Nil(t, flag.Set(name+".http-proxy", "proxy:3128"))
+	ensure.Nil(t, flag.Set(name+".proxy-rule", "*.example.com=direct"))
+	ensure.NotNil(t, flag.Set(name+".proxy-rule", "direct"))
+	ensure.DeepEqual(t, c.ProxySelector.HTTPProxy, "proxy:3128")
+	ensure.DeepEqual(t, c.ProxySelector.Rules, []httpcontrol.ProxyRule{
+		{Pattern: "*.example.com", Proxy: "direct"},
+	})
+}
+
+func TestFlagTLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(sleepHandler(0))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ensure.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0600))
+
+	name := flagName()
+	c := httpcontrol.TransportFlag(name)
+	ensure.Nil(t, flag.Set(name+".ca-file", caFile))
+	ensure.Nil(t, flag.Set(name+".tls-min-version", "1.3"))
+	ensure.Nil(t, flag.Set(name+".tls-server-name", "example.com"))
+	client := &http.Client{Transport: c}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.DeepEqual(t, res.TLS.Version, uint16(tls.VersionTLS13))
+}
+
+func TestFlagTLSOptionsErrors(t *testing.T) {
+	cases := map[string]string{
+		".ca-file":           "/does/not/exist",
+		".client-cert":       "client.pem",
+		".tls-min-version":   "1.4",
+		".tls-cipher-policy": "weak",
+	}
+	for suffix, value := range cases {
+		name := flagName()
+		c := httpcontrol.TransportFlag(name)
+		ensure.Nil(t, flag.Set(name+suffix, value))
+		client := &http.Client{Transport: c}
+		_, err := client.Get("https://example.com/")
+		ensure.Err(t, err, regexp.MustCompile("httpcontrol: "))
+	}
+}
+
 func TestStatsString(t *testing.T) {
 	s := httpcontrol.Stats{
 		Request: &http.Request{
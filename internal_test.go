@@ -1,6 +1,7 @@
 package httpcontrol
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -44,14 +45,93 @@ func TestShouldNotRetryRandomError(t *testing.T) {
 }
 
 func TestCancelRequest(t *testing.T) {
-	var called bool
-	timer := time.AfterFunc(time.Hour, func() { called = true })
 	var r Transport
-	r.CancelRequest(&http.Request{
-		Body: &bodyCloser{
-			timer: timer,
-		},
-	})
-	ensure.False(t, called)
-	ensure.False(t, timer.Stop())
+	r.CancelRequest(&http.Request{})
+}
+
+func TestCancelRequestSharedByCalls(t *testing.T) {
+	var f inflight
+	req := new(http.Request)
+	first, firstDone, err := f.begin(req)
+	ensure.Nil(t, err)
+	second, secondDone, err := f.begin(req)
+	ensure.Nil(t, err)
+
+	// finishing one call must not forget the other
+	firstDone()
+	ensure.NotNil(t, first.Err())
+	ensure.Nil(t, second.Err())
+	f.cancel(req)
+	ensure.NotNil(t, second.Err())
+	secondDone()
+	ensure.DeepEqual(t, len(f.calls), 0)
+}
+
+func TestEjectionTimeDoubles(t *testing.T) {
+	h := &HealthCheck{
+		BaseEjectionTime: time.Second,
+		MaxEjectionTime:  5 * time.Second,
+	}
+	ensure.DeepEqual(t, h.ejectionTime(1), time.Second)
+	ensure.DeepEqual(t, h.ejectionTime(2), 2*time.Second)
+	ensure.DeepEqual(t, h.ejectionTime(3), 4*time.Second)
+	ensure.DeepEqual(t, h.ejectionTime(4), 5*time.Second)
+}
+
+func TestOutliersSweep(t *testing.T) {
+	o := newOutliers(&HealthCheck{Window: 20 * time.Millisecond})
+	used, _ := http.NewRequest("GET", "http://used.example.com/", nil)
+	unused, _ := http.NewRequest("GET", "http://unused.example.com/", nil)
+	ensure.Nil(t, o.check(used))
+	ensure.Nil(t, o.check(unused))
+	time.Sleep(15 * time.Millisecond)
+	ensure.Nil(t, o.check(used))
+	time.Sleep(15 * time.Millisecond)
+	ensure.Nil(t, o.check(used))
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ensure.DeepEqual(t, len(o.hosts), 1)
+	ensure.NotNil(t, o.hosts["used.example.com"])
+}
+
+func TestLimiterAdmitsByPriority(t *testing.T) {
+	l := newLimiter(1, 2)
+	release, err := l.acquire(context.Background(), PriorityNormal)
+	ensure.Nil(t, err)
+
+	admitted := make(chan Priority, 2)
+	for _, p := range []Priority{PriorityLow, PriorityHigh} {
+		go func(p Priority) {
+			release, err := l.acquire(context.Background(), p)
+			ensure.Nil(t, err)
+			admitted <- p
+			release()
+		}(p)
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+	ensure.DeepEqual(t, <-admitted, PriorityHigh)
+	ensure.DeepEqual(t, <-admitted, PriorityLow)
+}
+
+func TestForHost(t *testing.T) {
+	tr := &Transport{Hosts: map[string]*HostSettings{
+		"example.com:8080":  {MaxTries: 1},
+		"example.com":       {MaxTries: 2},
+		"*.example.com":     {MaxTries: 3},
+		"*.api.example.com": {MaxTries: 4},
+	}}
+	tr.startOnce.Do(tr.start)
+	cases := map[string]uint{
+		"example.com:8080":   1,
+		"example.com":        2,
+		"example.com:443":    2,
+		"www.example.com":    3,
+		"v1.api.example.com": 4,
+		"example.org":        0,
+		"notexample.com":     0,
+	}
+	for host, tries := range cases {
+		ensure.DeepEqual(t, tr.forHost(host).MaxTries, tries, host)
+	}
 }
@@ -0,0 +1,198 @@
+package httpcontrol
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+)
+
+// Priority of a request for admission when MaxConcurrentRequests is set.
+// Requests with a higher priority are admitted first, and requests with a
+// lower priority are shed first when the queue is full. Any value may be
+// used, the constants are provided for convenience.
+type Priority int
+
+// Predefined priorities. Requests without a priority are PriorityNormal.
+const (
+	PriorityBatch    Priority = -10
+	PriorityLow      Priority = -5
+	PriorityNormal   Priority = 0
+	PriorityHigh     Priority = 5
+	PriorityCritical Priority = 10
+)
+
+// ErrShed is returned for requests that were dropped because the Transport was
+// saturated.
+var ErrShed = errors.New("httpcontrol: request shed due to load")
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying the given request priority. Use
+// it with http.Request.WithContext.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+	index    int
+	ready    chan error
+}
+
+// waiters is a heap ordered by priority, and by arrival within a priority.
+type waiters []*waiter
+
+func (w waiters) Len() int { return len(w) }
+
+func (w waiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w waiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *waiters) Push(x interface{}) {
+	wt := x.(*waiter)
+	wt.index = len(*w)
+	*w = append(*w, wt)
+}
+
+func (w *waiters) Pop() interface{} {
+	old := *w
+	wt := old[len(old)-1]
+	*w = old[:len(old)-1]
+	wt.index = -1
+	return wt
+}
+
+// limiter admits up to max concurrent requests, queueing up to maxQueue more
+// in priority order.
+type limiter struct {
+	max      int
+	maxQueue int
+
+	mu     sync.Mutex
+	active int
+	seq    uint64
+	queue  waiters
+	shed   map[Priority]uint64
+}
+
+func newLimiter(max, maxQueue int) *limiter {
+	return &limiter{
+		max:      max,
+		maxQueue: maxQueue,
+		shed:     make(map[Priority]uint64),
+	}
+}
+
+// acquire blocks until the request is admitted, it is shed or the context is
+// done. The returned function must be called once the request is finished.
+func (l *limiter) acquire(ctx context.Context, p Priority) (func(), error) {
+	l.mu.Lock()
+	if l.active < l.max && len(l.queue) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+
+	if len(l.queue) >= l.maxQueue {
+		lowest := l.lowest()
+		if lowest == nil || lowest.priority >= p {
+			l.shed[p]++
+			l.mu.Unlock()
+			return nil, ErrShed
+		}
+		heap.Remove(&l.queue, lowest.index)
+		l.shed[lowest.priority]++
+		lowest.ready <- ErrShed
+	}
+
+	w := &waiter{priority: p, seq: l.seq, ready: make(chan error, 1)}
+	l.seq++
+	heap.Push(&l.queue, w)
+	l.mu.Unlock()
+
+	select {
+	case err := <-w.ready:
+		if err != nil {
+			return nil, err
+		}
+		return l.releaser(), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&l.queue, w.index)
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		l.mu.Unlock()
+		// We were admitted or shed concurrently with the context expiring.
+		if err := <-w.ready; err == nil {
+			l.releaser()()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// lowest returns the queued waiter that would be shed first. Must be called
+// with the lock held.
+func (l *limiter) lowest() *waiter {
+	var lowest *waiter
+	for _, w := range l.queue {
+		if lowest == nil || w.priority < lowest.priority ||
+			(w.priority == lowest.priority && w.seq > lowest.seq) {
+			lowest = w
+		}
+	}
+	return lowest
+}
+
+func (l *limiter) releaser() func() {
+	var once sync.Once
+	return func() { once.Do(l.release) }
+}
+
+// release hands the slot to the highest priority waiter, if any.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.queue) > 0 {
+		w := heap.Pop(&l.queue).(*waiter)
+		w.ready <- nil
+		return
+	}
+	l.active--
+}
+
+func (l *limiter) shedCounts() map[Priority]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counts := make(map[Priority]uint64, len(l.shed))
+	for p, n := range l.shed {
+		counts[p] = n
+	}
+	return counts
+}
+
+// ShedCounts returns the number of requests shed so far, by priority.
+func (t *Transport) ShedCounts() map[Priority]uint64 {
+	t.startOnce.Do(t.start)
+	if t.limiter == nil {
+		return map[Priority]uint64{}
+	}
+	return t.limiter.shedCounts()
+}
@@ -0,0 +1,70 @@
+package httpcontrol_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func getWithPriority(client *http.Client, u string, p httpcontrol.Priority) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(httpcontrol.WithPriority(req.Context(), p))
+	return client.Do(req)
+}
+
+func TestLoadSheddingByPriority(t *testing.T) {
+	t.Parallel()
+	hit := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			hit <- struct{}{}
+			<-release
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		MaxConcurrentRequests: 1,
+		MaxQueuedRequests:     1,
+	}
+	client := &http.Client{Transport: transport}
+
+	errs := make(chan error, 3)
+	get := func(p httpcontrol.Priority) {
+		res, err := getWithPriority(client, server.URL, p)
+		if err == nil {
+			assertResponse(res, t)
+		}
+		errs <- err
+	}
+
+	go get(httpcontrol.PriorityNormal)
+	<-hit
+	go get(httpcontrol.PriorityLow)
+	time.Sleep(20 * time.Millisecond)
+	go get(httpcontrol.PriorityCritical)
+
+	// the low priority request is pushed out of the queue
+	err := <-errs
+	ensure.DeepEqual(t, err.(*url.Error).Err, httpcontrol.ErrShed)
+
+	// and a batch request is shed right away since the queue is full
+	_, err = getWithPriority(client, server.URL, httpcontrol.PriorityBatch)
+	ensure.DeepEqual(t, err.(*url.Error).Err, httpcontrol.ErrShed)
+
+	close(release)
+	ensure.Nil(t, <-errs)
+	ensure.Nil(t, <-errs)
+	ensure.DeepEqual(t, transport.ShedCounts(), map[httpcontrol.Priority]uint64{
+		httpcontrol.PriorityLow:   1,
+		httpcontrol.PriorityBatch: 1,
+	})
+}
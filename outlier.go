@@ -0,0 +1,273 @@
+package httpcontrol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck configures passive outlier ejection and optional active health
+// checking for the hosts a Transport talks to. Hosts are tracked as they are
+// seen in request URLs. Requests to an ejected host fail immediately with a
+// *HostEjectedError until the ejection period is over.
+type HealthCheck struct {
+	// Path, if non-empty, enables active health checking. Every Interval a GET
+	// for Path is issued to each known host. A RoundTrip error or a 5xx
+	// response counts as a failure, anything else as a success. A successful
+	// check readmits an ejected host immediately.
+	Path string
+
+	// Interval between active health checks. It is also the timeout for each
+	// check. The default is 10 seconds.
+	Interval time.Duration
+
+	// ConsecutiveFailures, if non-zero, ejects a host after this many failures
+	// in a row.
+	ConsecutiveFailures uint
+
+	// ErrorRate, if non-zero, ejects a host when the fraction of failures
+	// within Window reaches this value. It only applies once at least
+	// MinRequests have been seen within the Window.
+	ErrorRate   float64
+	MinRequests uint
+
+	// Window is the period over which the ErrorRate is computed. The default
+	// is 10 seconds.
+	Window time.Duration
+
+	// BaseEjectionTime is how long a host is ejected the first time. Each
+	// subsequent ejection without an intervening success doubles the period,
+	// up to MaxEjectionTime. The defaults are 30 seconds and 5 minutes.
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+}
+
+func (h *HealthCheck) interval() time.Duration {
+	if h.Interval == 0 {
+		return 10 * time.Second
+	}
+	return h.Interval
+}
+
+func (h *HealthCheck) window() time.Duration {
+	if h.Window == 0 {
+		return 10 * time.Second
+	}
+	return h.Window
+}
+
+func (h *HealthCheck) baseEjectionTime() time.Duration {
+	if h.BaseEjectionTime == 0 {
+		return 30 * time.Second
+	}
+	return h.BaseEjectionTime
+}
+
+func (h *HealthCheck) maxEjectionTime() time.Duration {
+	if h.MaxEjectionTime == 0 {
+		return 5 * time.Minute
+	}
+	return h.MaxEjectionTime
+}
+
+func (h *HealthCheck) ejectionTime(ejections uint) time.Duration {
+	max := h.maxEjectionTime()
+	d := h.baseEjectionTime()
+	for i := uint(1); i < ejections && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// HostEjectedError is returned for requests to a host that has been ejected
+// by the HealthCheck.
+type HostEjectedError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *HostEjectedError) Error() string {
+	return fmt.Sprintf("httpcontrol: host %s ejected until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+type hostHealth struct {
+	scheme       string
+	consecutive  uint
+	ejections    uint
+	ejectedUntil time.Time
+	windowStart  time.Time
+	requests     uint
+	failures     uint
+	lastUsed     time.Time
+}
+
+type outliers struct {
+	config *HealthCheck
+	mu     sync.Mutex
+	hosts  map[string]*hostHealth
+	swept  time.Time
+}
+
+func newOutliers(config *HealthCheck) *outliers {
+	return &outliers{
+		config: config,
+		hosts:  make(map[string]*hostHealth),
+	}
+}
+
+func (o *outliers) host(scheme, host string) *hostHealth {
+	h := o.hosts[host]
+	if h == nil {
+		now := time.Now()
+		h = &hostHealth{scheme: scheme, windowStart: now, lastUsed: now}
+		o.hosts[host] = h
+	}
+	return h
+}
+
+// sweep forgets the hosts that are not ejected and have not been requested
+// for longer than the Window, so that they are no longer probed. Must be
+// called with the lock held.
+func (o *outliers) sweep(now time.Time) {
+	window := o.config.window()
+	if now.Sub(o.swept) < window {
+		return
+	}
+	o.swept = now
+	for host, h := range o.hosts {
+		if now.Sub(h.lastUsed) > window && !now.Before(h.ejectedUntil) {
+			delete(o.hosts, host)
+		}
+	}
+}
+
+// check returns a non-nil error if the host is currently ejected.
+func (o *outliers) check(req *http.Request) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.sweep(now)
+	h := o.host(req.URL.Scheme, req.URL.Host)
+	h.lastUsed = now
+	if now.Before(h.ejectedUntil) {
+		return &HostEjectedError{Host: req.URL.Host, Until: h.ejectedUntil}
+	}
+	return nil
+}
+
+// record accounts for the outcome of a request and ejects the host if it
+// crossed one of the thresholds. Requests that failed because their caller
+// gave up are not accounted for.
+func (o *outliers) record(req *http.Request, res *http.Response, err error) {
+	if err != nil && req.Context().Err() != nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update(o.host(req.URL.Scheme, req.URL.Host), res, err)
+}
+
+// update accounts for the outcome of a request to the host. Must be called
+// with the lock held.
+func (o *outliers) update(h *hostHealth, res *http.Response, err error) {
+	failed := err != nil || res.StatusCode >= 500
+	now := time.Now()
+	if now.Sub(h.windowStart) > o.config.window() {
+		h.windowStart = now
+		h.requests = 0
+		h.failures = 0
+	}
+	h.requests++
+	if !failed {
+		h.consecutive = 0
+		if now.After(h.ejectedUntil) {
+			h.ejections = 0
+		}
+		return
+	}
+	h.failures++
+	h.consecutive++
+	if now.Before(h.ejectedUntil) {
+		return
+	}
+
+	eject := o.config.ConsecutiveFailures != 0 &&
+		h.consecutive >= o.config.ConsecutiveFailures
+	if o.config.ErrorRate != 0 && h.requests >= o.config.MinRequests &&
+		float64(h.failures)/float64(h.requests) >= o.config.ErrorRate {
+		eject = true
+	}
+	if eject {
+		h.ejections++
+		h.ejectedUntil = now.Add(o.config.ejectionTime(h.ejections))
+		h.consecutive = 0
+		h.windowStart = now
+		h.requests = 0
+		h.failures = 0
+	}
+}
+
+// readmit clears an ejection after a successful active health check.
+func (o *outliers) readmit(host string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if h := o.hosts[host]; h != nil {
+		h.ejectedUntil = time.Time{}
+		h.consecutive = 0
+	}
+}
+
+// probe runs the active health checks until stop is closed.
+func (o *outliers) probe(rt http.RoundTripper, stop <-chan struct{}) {
+	interval := o.config.interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		o.mu.Lock()
+		targets := make(map[string]string, len(o.hosts))
+		for host, h := range o.hosts {
+			targets[host] = h.scheme
+		}
+		o.mu.Unlock()
+
+		for host, scheme := range targets {
+			o.probeHost(rt, scheme, host, interval)
+		}
+	}
+}
+
+func (o *outliers) probeHost(rt http.RoundTripper, scheme, host string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", scheme+"://"+host+o.config.Path, nil)
+	if err != nil {
+		return
+	}
+	res, err := rt.RoundTrip(req.WithContext(ctx))
+	if err == nil {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+	// Probes do not keep swept hosts around, and their timeouts count as
+	// failures.
+	o.mu.Lock()
+	if h := o.hosts[host]; h != nil {
+		o.update(h, res, err)
+	}
+	o.mu.Unlock()
+	if err == nil && res.StatusCode < 500 {
+		o.readmit(host)
+	}
+}
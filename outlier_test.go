@@ -0,0 +1,181 @@
+package httpcontrol_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestConsecutiveFailuresEject(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(errorHandler(0))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		HealthCheck: &httpcontrol.HealthCheck{
+			ConsecutiveFailures: 2,
+			BaseEjectionTime:    50 * time.Millisecond,
+		},
+	}
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		assertResponse(res, t)
+	}
+
+	_, err := client.Get(server.URL)
+	ensure.NotNil(t, err)
+	ejected, ok := err.(*url.Error).Err.(*httpcontrol.HostEjectedError)
+	ensure.True(t, ok, err)
+	ensure.DeepEqual(t, ejected.Host, server.Listener.Addr().String())
+
+	time.Sleep(60 * time.Millisecond)
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+}
+
+func TestErrorRateEject(t *testing.T) {
+	t.Parallel()
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&count, 1)%2 == 0 {
+				w.WriteHeader(503)
+			}
+			w.Write(theAnswer)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		HealthCheck: &httpcontrol.HealthCheck{
+			ErrorRate:        0.5,
+			MinRequests:      4,
+			BaseEjectionTime: time.Hour,
+		},
+	}
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 4; i++ {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		assertResponse(res, t)
+	}
+	_, err := client.Get(server.URL)
+	ensure.NotNil(t, err)
+}
+
+func TestActiveHealthCheckReadmits(t *testing.T) {
+	t.Parallel()
+	var healthy int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" && atomic.LoadInt32(&healthy) == 1 {
+				return
+			}
+			w.WriteHeader(500)
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		HealthCheck: &httpcontrol.HealthCheck{
+			Path:                "/health",
+			Interval:            10 * time.Millisecond,
+			ConsecutiveFailures: 1,
+			BaseEjectionTime:    time.Hour,
+		},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL + "/health")
+	ensure.Nil(t, err)
+	res.Body.Close()
+	_, err = client.Get(server.URL + "/health")
+	ensure.NotNil(t, err)
+
+	atomic.StoreInt32(&healthy, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err = client.Get(server.URL + "/health")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("host was not readmitted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestActiveHealthCheckUsesHostSettings(t *testing.T) {
+	t.Parallel()
+	var healthy int32
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" && atomic.LoadInt32(&healthy) == 1 {
+				return
+			}
+			w.WriteHeader(500)
+		}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+	// only the host settings trust the certificate of the server
+	transport := &httpcontrol.Transport{
+		Hosts: map[string]*httpcontrol.HostSettings{
+			host: {TLSClientConfig: server.Client().Transport.(*http.Transport).TLSClientConfig},
+		},
+		HealthCheck: &httpcontrol.HealthCheck{
+			Path:                "/health",
+			Interval:            10 * time.Millisecond,
+			ConsecutiveFailures: 1,
+			BaseEjectionTime:    time.Hour,
+		},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL + "/health")
+	ensure.Nil(t, err)
+	res.Body.Close()
+	_, err = client.Get(server.URL + "/health")
+	ensure.NotNil(t, err)
+
+	atomic.StoreInt32(&healthy, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err = client.Get(server.URL + "/health")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("host was not readmitted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCanceledRequestsNotRecorded(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(50 * time.Millisecond))
+	defer server.Close()
+	transport := &httpcontrol.Transport{
+		HealthCheck: &httpcontrol.HealthCheck{
+			ConsecutiveFailures: 1,
+			BaseEjectionTime:    time.Hour,
+		},
+	}
+	client := &http.Client{Transport: transport}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest("GET", server.URL, nil)
+	ensure.Nil(t, err)
+	_, err = client.Do(req.WithContext(ctx))
+	ensure.NotNil(t, err)
+
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+}
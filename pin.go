@@ -0,0 +1,67 @@
+package httpcontrol
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// PinMismatchError is returned when the certificate chain of a host does not
+// contain any of its Pins. Requests failing with it are not retried.
+type PinMismatchError struct {
+	Host string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("httpcontrol: certificate chain of %s does not match any pin", e.Host)
+}
+
+// SPKIHash returns the pin of the certificate for use in Transport.Pins: the
+// base64 encoded SHA-256 hash of its Subject Public Key Info.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPins returns a VerifyConnection function that checks the pins of the
+// host before calling next, if it is non-nil. If host is empty, the server
+// name of the connection is used, which is not set for IP addresses.
+func (t *Transport) verifyPins(host string, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		name := host
+		if name == "" {
+			name = state.ServerName
+		}
+		if pins, ok := t.Pins[name]; ok && !matchPins(pins, state) {
+			return &PinMismatchError{Host: name}
+		}
+		if next != nil {
+			return next(state)
+		}
+		return nil
+	}
+}
+
+// matchPins returns true if a certificate of the verified chains matches one
+// of the pins. If the chains were not verified, only the leaf certificate may
+// match, since anyone can present a copy of a public intermediate or CA
+// certificate after their own leaf.
+func matchPins(pins []string, state tls.ConnectionState) bool {
+	chains := state.VerifiedChains
+	if len(chains) == 0 && len(state.PeerCertificates) != 0 {
+		chains = [][]*x509.Certificate{state.PeerCertificates[:1]}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := SPKIHash(cert)
+			for _, pin := range pins {
+				if pin == hash {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package httpcontrol_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestPins(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(sleepHandler(0))
+	defer server.Close()
+	pin := httpcontrol.SPKIHash(server.Certificate())
+	transport := &httpcontrol.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		Pins:            map[string][]string{"127.0.0.1": {"backup", pin}},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+}
+
+func TestPinMismatchNotRetried(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(sleepHandler(0))
+	defer server.Close()
+	var tries int
+	transport := &httpcontrol.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		Pins:              map[string][]string{"127.0.0.1": {"backup"}},
+		MaxTries:          3,
+		RetryAfterTimeout: true,
+		Stats:             func(*httpcontrol.Stats) { tries++ },
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get(server.URL)
+	var pinErr *httpcontrol.PinMismatchError
+	ensure.True(t, errors.As(err, &pinErr))
+	ensure.DeepEqual(t, pinErr.Host, "127.0.0.1")
+	ensure.DeepEqual(t, tries, 1)
+}
+
+func TestPinsUnverifiedChainOnlyMatchLeaf(t *testing.T) {
+	t.Parallel()
+	pinned := httptest.NewTLSServer(sleepHandler(0))
+	pinnedCert := pinned.Certificate()
+	pinned.Close()
+
+	// An unrelated leaf presented in front of a copy of the pinned
+	// certificate.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ensure.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ensure.Nil(t, err)
+	server := httptest.NewUnstartedServer(sleepHandler(0))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leaf, pinnedCert.Raw},
+			PrivateKey:  key,
+		}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	transport := &httpcontrol.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		Pins:            map[string][]string{"127.0.0.1": {httpcontrol.SPKIHash(pinnedCert)}},
+	}
+	client := &http.Client{Transport: transport}
+	_, err = client.Get(server.URL)
+	var pinErr *httpcontrol.PinMismatchError
+	ensure.True(t, errors.As(err, &pinErr), err)
+}
@@ -0,0 +1,294 @@
+package httpcontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ProxyError is returned when a request fails because of its proxy: the
+// proxy could not be reached, refused a CONNECT request or required
+// authentication. Errors with a StatusCode are not retried, others are
+// retried like the underlying error.
+type ProxyError struct {
+	// The address of the proxy.
+	Proxy string
+
+	// The status of the proxy's response, if it responded.
+	StatusCode int
+
+	Err error
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("httpcontrol: proxy %s: %v", e.Proxy, e.Err)
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// ProxyRule sends the requests to the hosts matching Pattern through Proxy.
+// Patterns are as in Transport.Hosts. An empty Proxy or "direct" means no
+// proxy is used.
+type ProxyRule struct {
+	Pattern string `json:"pattern"`
+	Proxy   string `json:"proxy"`
+}
+
+// ProxySelector chooses the proxy of each request using rules, for use as the
+// Transport's ProxySelector or Proxy. A ProxySelector must not be modified
+// after first use.
+//
+// The first of the Rules matching the request host applies. Otherwise hosts
+// excluded by NoProxy are not proxied, and other requests use HTTPSProxy or
+// HTTPProxy depending on their scheme.
+type ProxySelector struct {
+	// HTTPProxy and HTTPSProxy are the proxy URLs for http and https
+	// requests. A URL without a scheme is taken to be an http one.
+	HTTPProxy, HTTPSProxy string
+
+	// NoProxy is a comma or space separated list of exclusions, with the
+	// semantics of the NO_PROXY environment variable: "*" excludes all
+	// hosts, "example.com" excludes the domain and its subdomains,
+	// ".example.com" only its subdomains, and IP addresses and CIDR blocks
+	// such as "10.0.0.0/8" exclude the hosts given as matching IPs. An
+	// entry may include a port to only exclude that port.
+	NoProxy string
+
+	// Rules are checked in order before the other settings.
+	Rules []ProxyRule
+
+	// FromEnvironment, if true, fills the empty HTTPProxy, HTTPSProxy and
+	// NoProxy from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables, or their lowercase versions.
+	FromEnvironment bool
+
+	once     sync.Once
+	err      error
+	http     *url.URL
+	https    *url.URL
+	noProxy  []noProxyEntry
+	rules    []*url.URL
+	matchAll bool
+}
+
+type noProxyEntry struct {
+	domain string // matched against the host name, if set
+	exact  bool   // the domain itself matches, not just its subdomains
+	ipNet  *net.IPNet
+	port   string
+}
+
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// parseProxyURL parses a proxy URL, returning nil for no proxy.
+func parseProxyURL(raw string) (*url.URL, error) {
+	if raw == "" || raw == "direct" {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("httpcontrol: invalid proxy URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("httpcontrol: invalid proxy URL %q: missing host", raw)
+	}
+	return u, nil
+}
+
+func (s *ProxySelector) compile() error {
+	s.once.Do(func() {
+		httpProxy, httpsProxy, noProxy := s.HTTPProxy, s.HTTPSProxy, s.NoProxy
+		if s.FromEnvironment {
+			if httpProxy == "" {
+				httpProxy = getenv("HTTP_PROXY")
+			}
+			if httpsProxy == "" {
+				httpsProxy = getenv("HTTPS_PROXY")
+			}
+			if noProxy == "" {
+				noProxy = getenv("NO_PROXY")
+			}
+		}
+		if s.http, s.err = parseProxyURL(httpProxy); s.err != nil {
+			return
+		}
+		if s.https, s.err = parseProxyURL(httpsProxy); s.err != nil {
+			return
+		}
+		for _, rule := range s.Rules {
+			var u *url.URL
+			if u, s.err = parseProxyURL(rule.Proxy); s.err != nil {
+				return
+			}
+			s.rules = append(s.rules, u)
+		}
+		for _, field := range strings.FieldsFunc(noProxy, func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			if field == "*" {
+				s.matchAll = true
+				continue
+			}
+			if _, ipNet, err := net.ParseCIDR(field); err == nil {
+				s.noProxy = append(s.noProxy, noProxyEntry{ipNet: ipNet})
+				continue
+			}
+			var e noProxyEntry
+			host, port, err := net.SplitHostPort(field)
+			if err != nil {
+				host = field
+			} else {
+				e.port = port
+			}
+			if ip := net.ParseIP(host); ip != nil {
+				bits := 8 * len(ip)
+				e.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			} else {
+				e.exact = !strings.HasPrefix(host, ".")
+				e.domain = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(host, "*"), "."))
+			}
+			s.noProxy = append(s.noProxy, e)
+		}
+	})
+	return s.err
+}
+
+// empty returns true if the selector has no settings, as allocated by
+// TransportFlagSet.
+func (s *ProxySelector) empty() bool {
+	return s.HTTPProxy == "" && s.HTTPSProxy == "" && s.NoProxy == "" &&
+		len(s.Rules) == 0 && !s.FromEnvironment
+}
+
+// excluded returns true if the host and port are excluded by NoProxy.
+func (s *ProxySelector) excluded(host, port string) bool {
+	if s.matchAll {
+		return true
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, e := range s.noProxy {
+		if e.port != "" && e.port != port {
+			continue
+		}
+		if e.ipNet != nil {
+			if ip != nil && e.ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if (e.exact && host == e.domain) || strings.HasSuffix(host, "."+e.domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Proxy returns the proxy to use for the request, or nil if none should be
+// used. It returns an error if the settings are invalid.
+func (s *ProxySelector) Proxy(req *http.Request) (*url.URL, error) {
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	for i, rule := range s.Rules {
+		if matchHost(rule.Pattern, net.JoinHostPort(host, port)) {
+			return s.rules[i], nil
+		}
+	}
+	if s.excluded(host, port) {
+		return nil, nil
+	}
+	if req.URL.Scheme == "https" {
+		return s.https, nil
+	}
+	return s.http, nil
+}
+
+// proxy returns the proxy to use for the request, as set by the Proxy
+// function or the ProxySelector, and records it in the request's dialInfo.
+func (t *Transport) proxy(req *http.Request) (*url.URL, error) {
+	var u *url.URL
+	var err error
+	switch {
+	case t.Proxy != nil:
+		u, err = t.Proxy(req)
+	case t.ProxySelector != nil:
+		u, err = t.ProxySelector.Proxy(req)
+	}
+	if u != nil {
+		dialInfoFrom(req.Context()).setProxy(u.Host)
+		if u.User == nil && t.ProxyUsername != "" {
+			auth := *u
+			auth.User = url.UserPassword(t.ProxyUsername, t.ProxyPassword)
+			u = &auth
+		}
+	}
+	return u, err
+}
+
+// onProxyConnectResponse turns the failed responses to CONNECT requests into
+// a ProxyError.
+func (t *Transport) onProxyConnectResponse(ctx context.Context, proxy *url.URL, req *http.Request, res *http.Response) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	return &ProxyError{
+		Proxy:      proxy.Host,
+		StatusCode: res.StatusCode,
+		Err:        errors.New(res.Status),
+	}
+}
+
+// proxyError returns the error as a ProxyError if it was caused by the proxy
+// of the request. The response is closed if it is turned into an error.
+func proxyError(res *http.Response, err error, info *dialInfo) (*http.Response, error) {
+	info.mu.Lock()
+	proxy := info.proxy
+	info.mu.Unlock()
+	if proxy == "" {
+		return res, err
+	}
+	if err == nil {
+		if res.StatusCode != http.StatusProxyAuthRequired {
+			return res, nil
+		}
+		res.Body.Close()
+		return nil, &ProxyError{
+			Proxy:      proxy,
+			StatusCode: res.StatusCode,
+			Err:        errors.New(res.Status),
+		}
+	}
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return nil, err
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return nil, &ProxyError{Proxy: proxy, Err: err}
+	}
+	return nil, err
+}
@@ -0,0 +1,229 @@
+package httpcontrol_test
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestProxySelector(t *testing.T) {
+	s := &httpcontrol.ProxySelector{
+		HTTPProxy:  "proxy:3128",
+		HTTPSProxy: "https://secure-proxy:3129",
+		NoProxy:    "example.com, .internal 10.0.0.0/8,192.168.1.1,db:5432",
+		Rules: []httpcontrol.ProxyRule{
+			{Pattern: "*.partner.com", Proxy: "http://partner-proxy:8080"},
+			{Pattern: "direct.example.org", Proxy: "direct"},
+		},
+	}
+	cases := map[string]string{
+		"http://example.org/":          "http://proxy:3128",
+		"https://example.org/":         "https://secure-proxy:3129",
+		"http://example.com/":          "",
+		"http://www.example.com/":      "",
+		"http://notexample.com/":       "http://proxy:3128",
+		"http://internal/":             "http://proxy:3128",
+		"http://db.internal/":          "",
+		"http://10.1.2.3/":             "",
+		"http://11.1.2.3/":             "http://proxy:3128",
+		"http://192.168.1.1:8080/":     "",
+		"http://db:5432/":              "",
+		"http://db:5433/":              "http://proxy:3128",
+		"https://api.partner.com/":     "http://partner-proxy:8080",
+		"http://direct.example.org/x/": "",
+	}
+	for raw, expected := range cases {
+		u, _ := url.Parse(raw)
+		proxy, err := s.Proxy(&http.Request{URL: u})
+		ensure.Nil(t, err)
+		var actual string
+		if proxy != nil {
+			actual = proxy.String()
+		}
+		ensure.DeepEqual(t, actual, expected, raw)
+	}
+}
+
+func TestProxySelectorMatchAll(t *testing.T) {
+	s := &httpcontrol.ProxySelector{HTTPProxy: "proxy:3128", NoProxy: "*"}
+	u, _ := url.Parse("http://example.com/")
+	proxy, err := s.Proxy(&http.Request{URL: u})
+	ensure.Nil(t, err)
+	ensure.True(t, proxy == nil)
+}
+
+func TestProxySelectorFromEnvironment(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("http_proxy", "env-proxy:3128")
+	t.Setenv("NO_PROXY", "example.com")
+	s := &httpcontrol.ProxySelector{FromEnvironment: true}
+	for raw, expected := range map[string]string{
+		"http://example.org/": "http://env-proxy:3128",
+		"http://example.com/": "",
+	} {
+		u, _ := url.Parse(raw)
+		proxy, err := s.Proxy(&http.Request{URL: u})
+		ensure.Nil(t, err)
+		var actual string
+		if proxy != nil {
+			actual = proxy.String()
+		}
+		ensure.DeepEqual(t, actual, expected, raw)
+	}
+}
+
+func TestProxySelectorStats(t *testing.T) {
+	t.Parallel()
+	var target string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.String()
+		w.Write(theAnswer)
+	}))
+	defer proxy.Close()
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		ProxySelector: &httpcontrol.ProxySelector{HTTPProxy: proxy.URL},
+		Stats:         func(s *httpcontrol.Stats) { stats = *s },
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get("http://example.com/path")
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.DeepEqual(t, target, "http://example.com/path")
+	ensure.DeepEqual(t, stats.Proxy, proxy.Listener.Addr().String())
+}
+
+func TestProxySelectorInvalid(t *testing.T) {
+	transport := &httpcontrol.Transport{
+		ProxySelector: &httpcontrol.ProxySelector{HTTPProxy: "http://"},
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get("http://example.com/")
+	ensure.NotNil(t, err)
+}
+
+// authProxy is an HTTP proxy requiring Basic authentication as user:secret
+// and, for CONNECT requests, the X-Tenant header.
+func authProxy() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if r.Header.Get("Proxy-Authorization") != expected {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != "CONNECT" {
+			w.Write(theAnswer)
+			return
+		}
+		if r.Header.Get("X-Tenant") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go io.Copy(upstream, buf)
+		io.Copy(conn, upstream)
+	}))
+}
+
+func TestProxyAuth(t *testing.T) {
+	t.Parallel()
+	proxy := authProxy()
+	defer proxy.Close()
+	transport := &httpcontrol.Transport{
+		ProxySelector: &httpcontrol.ProxySelector{HTTPProxy: proxy.URL},
+		ProxyUsername: "user",
+		ProxyPassword: "secret",
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get("http://example.com/")
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+}
+
+func TestProxyAuthRequired(t *testing.T) {
+	t.Parallel()
+	proxy := authProxy()
+	defer proxy.Close()
+	var tries int
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		ProxySelector: &httpcontrol.ProxySelector{HTTPProxy: proxy.URL},
+		ProxyUsername: "user",
+		ProxyPassword: "wrong",
+		MaxTries:      3,
+		Stats: func(s *httpcontrol.Stats) {
+			tries++
+			stats = *s
+		},
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get("http://example.com/")
+	var proxyErr *httpcontrol.ProxyError
+	ensure.True(t, errors.As(err, &proxyErr))
+	ensure.DeepEqual(t, proxyErr.StatusCode, http.StatusProxyAuthRequired)
+	ensure.DeepEqual(t, proxyErr.Proxy, proxy.Listener.Addr().String())
+	ensure.DeepEqual(t, tries, 1)
+	ensure.True(t, errors.As(stats.Error, &proxyErr))
+}
+
+func TestProxyConnectHeader(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(sleepHandler(0))
+	defer server.Close()
+	proxy := authProxy()
+	defer proxy.Close()
+	selector := &httpcontrol.ProxySelector{HTTPSProxy: "http://user:secret@" + proxy.Listener.Addr().String()}
+	transport := &httpcontrol.Transport{
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
+		ProxySelector:      selector,
+		ProxyConnectHeader: http.Header{"X-Tenant": {"a"}},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+
+	transport = &httpcontrol.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		ProxySelector:   selector,
+	}
+	client = &http.Client{Transport: transport}
+	_, err = client.Get(server.URL)
+	var proxyErr *httpcontrol.ProxyError
+	ensure.True(t, errors.As(err, &proxyErr))
+	ensure.DeepEqual(t, proxyErr.StatusCode, http.StatusForbidden)
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	t.Parallel()
+	proxy := httptest.NewServer(sleepHandler(0))
+	proxy.Close()
+	transport := &httpcontrol.Transport{
+		ProxySelector: &httpcontrol.ProxySelector{HTTPProxy: proxy.URL},
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get("http://example.com/")
+	var proxyErr *httpcontrol.ProxyError
+	ensure.True(t, errors.As(err, &proxyErr))
+	ensure.DeepEqual(t, proxyErr.StatusCode, 0)
+}
@@ -0,0 +1,124 @@
+package httpcontrol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrShutdown is returned for requests made after Shutdown has been called.
+var ErrShutdown = errors.New("httpcontrol: transport is shut down")
+
+// inflight tracks the requests whose response has not been fully consumed.
+type inflight struct {
+	mu       sync.Mutex
+	shutdown bool
+	count    int
+	idle     chan struct{}
+
+	// The calls in flight by request. Concurrent calls may share a request.
+	calls map[*http.Request]map[*inflightCall]struct{}
+}
+
+// inflightCall is a single RoundTrip in flight.
+type inflightCall struct {
+	cancel context.CancelFunc
+}
+
+// begin registers a new request. It returns a context to use for the request
+// and a function that must be called once the request is finished.
+func (f *inflight) begin(req *http.Request) (context.Context, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.shutdown {
+		return nil, nil, ErrShutdown
+	}
+	if f.calls == nil {
+		f.calls = make(map[*http.Request]map[*inflightCall]struct{})
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	call := &inflightCall{cancel: cancel}
+	if f.calls[req] == nil {
+		f.calls[req] = make(map[*inflightCall]struct{})
+	}
+	f.calls[req][call] = struct{}{}
+	f.count++
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			cancel()
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			delete(f.calls[req], call)
+			if len(f.calls[req]) == 0 {
+				delete(f.calls, req)
+			}
+			f.count--
+			if f.count == 0 && f.idle != nil {
+				close(f.idle)
+				f.idle = nil
+			}
+		})
+	}
+	return ctx, done, nil
+}
+
+// cancel cancels every in-flight call for the given request.
+func (f *inflight) cancel(req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for call := range f.calls[req] {
+		call.cancel()
+	}
+}
+
+// close stops accepting new requests and returns a channel that is closed
+// once there are no requests in flight.
+func (f *inflight) close() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shutdown = true
+	idle := make(chan struct{})
+	if f.count == 0 {
+		close(idle)
+	} else {
+		f.idle = idle
+	}
+	return idle
+}
+
+func (f *inflight) cancelAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, calls := range f.calls {
+		for call := range calls {
+			call.cancel()
+		}
+	}
+}
+
+// Shutdown gracefully shuts down the Transport. Requests made after Shutdown
+// is called fail with ErrShutdown. It then waits for in-flight requests to
+// finish, including their response bodies being closed. If ctx is done before
+// that, the remaining requests are canceled and ctx.Err() is returned.
+// Finally all connections are closed.
+func (t *Transport) Shutdown(ctx context.Context) error {
+	t.startOnce.Do(t.start)
+	idle := t.inflight.close()
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.derived(func(d *Transport) {
+		d.stopOnce.Do(func() { close(d.stop) })
+	})
+
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		t.inflight.cancelAll()
+	}
+	t.CloseIdleConnections()
+	return err
+}
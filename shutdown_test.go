@@ -0,0 +1,64 @@
+package httpcontrol_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestShutdownWaitsForInFlight(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(50 * time.Millisecond))
+	defer server.Close()
+	transport := &httpcontrol.Transport{}
+	client := &http.Client{Transport: transport}
+
+	var closing int32
+	go func() {
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		b, err := ioutil.ReadAll(res.Body)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, b, theAnswer)
+		atomic.StoreInt32(&closing, 1)
+		res.Body.Close()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ensure.Nil(t, transport.Shutdown(context.Background()))
+	ensure.DeepEqual(t, atomic.LoadInt32(&closing), int32(1))
+
+	_, err := client.Get(server.URL)
+	ensure.DeepEqual(t, err.(*url.Error).Err, httpcontrol.ErrShutdown)
+}
+
+func TestShutdownCancelsAfterDeadline(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(theAnswer[:1])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+	defer server.Close()
+	transport := &httpcontrol.Transport{}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ensure.DeepEqual(t, transport.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = ioutil.ReadAll(res.Body)
+	ensure.NotNil(t, err)
+	res.Body.Close()
+}
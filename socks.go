@@ -0,0 +1,207 @@
+package httpcontrol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion         = 5
+	socksNoAuth          = 0
+	socksPasswordAuth    = 2
+	socksNoAcceptable    = 0xff
+	socksConnect         = 1
+	socksAddrIPv4        = 1
+	socksAddrDomain      = 3
+	socksAddrIPv6        = 4
+	socksPasswordVersion = 1
+)
+
+var socksReplies = map[byte]string{
+	1: "general server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksProxy is a parsed SOCKS5Proxy.
+type socksProxy struct {
+	address  string
+	username string
+	password string
+}
+
+func parseSOCKS5Proxy(raw string) (*socksProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("httpcontrol: invalid SOCKS5 proxy: %w", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("httpcontrol: invalid SOCKS5 proxy %q: scheme must be socks5", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("httpcontrol: invalid SOCKS5 proxy %q: missing host", raw)
+	}
+	p := &socksProxy{address: u.Host}
+	if u.Port() == "" {
+		p.address = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	if u.User != nil {
+		p.username = u.User.Username()
+		p.password, _ = u.User.Password()
+		if len(p.username) > 255 || len(p.password) > 255 {
+			return nil, fmt.Errorf("httpcontrol: invalid SOCKS5 proxy %q: credentials too long", raw)
+		}
+	}
+	return p, nil
+}
+
+// dialSOCKS5 connects to the address through the SOCKS5Proxy. Host names are
+// resolved by the proxy. The whole dial, including the proxy handshake, is
+// limited by the DialTimeout.
+func (t *Transport) dialSOCKS5(ctx context.Context, network, address string) (net.Conn, error) {
+	if t.DialTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.DialTimeout)
+		defer cancel()
+	}
+	var conn net.Conn
+	var err error
+	if t.Dial != nil {
+		conn, err = t.Dial("tcp", t.socks.address)
+	} else {
+		conn, err = t.defaultDial(ctx, "tcp", t.socks.address)
+	}
+	if err != nil {
+		return nil, &ProxyError{Proxy: t.socks.address, Err: err}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	err = t.socks.handshake(conn, address)
+	if !stop() && err != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &ProxyError{
+			Proxy: t.socks.address,
+			Err:   &net.OpError{Op: "dial", Net: network, Err: err},
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake authenticates with the proxy and asks it to connect to the
+// address.
+func (p *socksProxy) handshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("httpcontrol: socks5: invalid port %q", portStr)
+	}
+
+	greeting := []byte{socksVersion, 1, socksNoAuth}
+	if p.username != "" {
+		greeting = []byte{socksVersion, 2, socksNoAuth, socksPasswordAuth}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("httpcontrol: socks5: unexpected protocol version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksPasswordAuth:
+		if p.username == "" {
+			return errors.New("httpcontrol: socks5: proxy requires authentication")
+		}
+		auth := []byte{socksPasswordVersion, byte(len(p.username))}
+		auth = append(auth, p.username...)
+		auth = append(auth, byte(len(p.password)))
+		auth = append(auth, p.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("httpcontrol: socks5: authentication failed")
+		}
+	case socksNoAcceptable:
+		return errors.New("httpcontrol: socks5: no acceptable authentication method")
+	default:
+		return fmt.Errorf("httpcontrol: socks5: unsupported authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("httpcontrol: socks5: host name too long: %s", host)
+		}
+		req = append(req, socksAddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socksAddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socksAddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		reason, ok := socksReplies[header[1]]
+		if !ok {
+			reason = fmt.Sprintf("unknown reply %d", header[1])
+		}
+		return fmt.Errorf("httpcontrol: socks5: connect to %s: %s", address, reason)
+	}
+	var skip int
+	switch header[3] {
+	case socksAddrIPv4:
+		skip = net.IPv4len
+	case socksAddrIPv6:
+		skip = net.IPv6len
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("httpcontrol: socks5: unexpected address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
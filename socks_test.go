@@ -0,0 +1,134 @@
+package httpcontrol_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+// socksServer is a minimal SOCKS5 proxy accepting the given credentials and
+// recording the hosts it was asked to connect to.
+type socksServer struct {
+	listener           net.Listener
+	username, password string
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newSOCKSServer(t *testing.T, username, password string) *socksServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ensure.Nil(t, err)
+	s := &socksServer{listener: l, username: username, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) URL() string {
+	return "socks5://" + s.username + ":" + s.password + "@" + s.listener.Addr().String()
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	username := make([]byte, buf[1])
+	io.ReadFull(conn, username)
+	io.ReadFull(conn, buf[:1])
+	password := make([]byte, buf[0])
+	io.ReadFull(conn, password)
+	if string(username) != s.username || string(password) != s.password {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil || buf[3] != 3 {
+		return
+	}
+	host := make([]byte, buf[4])
+	io.ReadFull(conn, host)
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	s.mu.Lock()
+	s.hosts = append(s.hosts, string(host))
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(string(host), strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(0))
+	defer server.Close()
+	proxy := newSOCKSServer(t, "user", "secret")
+	defer proxy.listener.Close()
+	var stats httpcontrol.Stats
+	transport := &httpcontrol.Transport{
+		SOCKS5Proxy: proxy.URL(),
+		Stats:       func(s *httpcontrol.Stats) { stats = *s },
+	}
+	client := &http.Client{Transport: transport}
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	res, err := client.Get("http://localhost:" + port + "/")
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	proxy.mu.Lock()
+	ensure.DeepEqual(t, proxy.hosts, []string{"localhost"})
+	proxy.mu.Unlock()
+	ensure.DeepEqual(t, stats.Proxy, proxy.listener.Addr().String())
+	ensure.DeepEqual(t, stats.RemoteAddr, proxy.listener.Addr().String())
+}
+
+func TestSOCKS5ProxyAuthFailure(t *testing.T) {
+	t.Parallel()
+	proxy := newSOCKSServer(t, "user", "secret")
+	defer proxy.listener.Close()
+	transport := &httpcontrol.Transport{
+		SOCKS5Proxy: "socks5://user:wrong@" + proxy.listener.Addr().String(),
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get("http://localhost/")
+	ensure.Err(t, err, regexp.MustCompile("socks5: authentication failed"))
+}
+
+func TestSOCKS5ProxyInvalid(t *testing.T) {
+	transport := &httpcontrol.Transport{SOCKS5Proxy: "http://proxy"}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get("http://localhost/")
+	ensure.Err(t, err, regexp.MustCompile("scheme must be socks5"))
+}
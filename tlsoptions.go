@@ -0,0 +1,105 @@
+package httpcontrol
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions are TLS settings given as strings and file paths, as used by
+// TransportFlag. They are loaded and validated when the Transport starts, and
+// applied on top of the TLSClientConfig. If they are invalid, requests fail
+// with the error.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the certificate authorities trusted instead
+	// of the system ones.
+	CAFile string
+
+	// CertFile and KeyFile are the client certificate and key presented to
+	// servers requesting one. They are reloaded when they change, see
+	// ClientCert.
+	CertFile, KeyFile string
+
+	// MinVersion is the minimum TLS version, one of "1.0", "1.1", "1.2" or
+	// "1.3".
+	MinVersion string
+
+	// ServerName overrides the name used to verify server certificates and
+	// sent using SNI.
+	ServerName string
+
+	// CipherPolicy is one of "default", which uses the crypto/tls defaults,
+	// "intermediate", which requires TLS 1.2 and forward secret AEAD cipher
+	// suites, or "modern", which requires TLS 1.3.
+	CipherPolicy string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// apply loads the options into the config.
+func (o *TLSOptions) apply(config *tls.Config) error {
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return fmt.Errorf("httpcontrol: reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("httpcontrol: no certificates found in CA bundle %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return fmt.Errorf("httpcontrol: client certificate and key must be given together")
+	}
+	if o.CertFile != "" {
+		cert := &ClientCert{CertFile: o.CertFile, KeyFile: o.KeyFile}
+		if err := cert.Reload(); err != nil {
+			return fmt.Errorf("httpcontrol: loading client certificate: %w", err)
+		}
+		config.Certificates = nil
+		config.GetClientCertificate = cert.GetClientCertificate
+	}
+
+	if o.MinVersion != "" {
+		version, ok := tlsVersions[o.MinVersion]
+		if !ok {
+			return fmt.Errorf("httpcontrol: unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", o.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+
+	switch o.CipherPolicy {
+	case "", "default":
+	case "intermediate":
+		if config.MinVersion < tls.VersionTLS12 {
+			config.MinVersion = tls.VersionTLS12
+		}
+		config.CipherSuites = intermediateCipherSuites
+	case "modern":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("httpcontrol: unknown cipher policy %q, expected default, intermediate or modern", o.CipherPolicy)
+	}
+	return nil
+}
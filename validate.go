@@ -0,0 +1,417 @@
+package httpcontrol
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Validate checks the settings for values that are invalid, or that interact
+// in ways that are probably unintended, such as a RequestTimeout shorter than
+// the ResponseHeaderTimeout. It returns nil, or an error joining one error per
+// problem found, as returned by errors.Join. A Transport that fails validation
+// can still be used.
+func (t *Transport) Validate() error {
+	v := new(validator)
+	v.timeouts(t)
+	reported := make(map[string]bool, len(v.problems))
+	for _, p := range v.problems {
+		reported[p] = true
+	}
+	v.transport(t)
+	problems := v.problems
+
+	patterns := make([]string, 0, len(t.Hosts))
+	for pattern := range t.Hosts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		s := t.Hosts[pattern]
+		if s == nil {
+			problems = append(problems, fmt.Sprintf("Hosts[%q] is nil", pattern))
+			continue
+		}
+		// Only report the problems the host settings introduce.
+		v.problems = nil
+		v.timeouts(t.overlay(s))
+		for _, p := range v.problems {
+			if !reported[p] {
+				problems = append(problems, fmt.Sprintf("Hosts[%q]: %s", pattern, p))
+			}
+		}
+	}
+
+	errs := make([]error, len(problems))
+	for i, p := range problems {
+		errs[i] = errors.New("httpcontrol: " + p)
+	}
+	return errors.Join(errs...)
+}
+
+// validator collects the problems found in the settings.
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) add(err error) {
+	v.problems = append(v.problems, strings.TrimPrefix(err.Error(), "httpcontrol: "))
+}
+
+// timeouts checks the settings that may be overridden by HostSettings.
+func (v *validator) timeouts(t *Transport) {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"RequestTimeout", t.RequestTimeout},
+		{"ResponseHeaderTimeout", t.ResponseHeaderTimeout},
+		{"DialTimeout", t.DialTimeout},
+		{"TLSHandshakeTimeout", t.TLSHandshakeTimeout},
+		{"IdleConnTimeout", t.IdleConnTimeout},
+		{"ExpectContinueTimeout", t.ExpectContinueTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			v.addf("%s %v is negative", d.name, d.value)
+		}
+	}
+	if t.RequestTimeout > 0 {
+		for _, d := range durations[1:4] {
+			if d.value > t.RequestTimeout {
+				v.addf("%s %v is longer than RequestTimeout %v, which fires first", d.name, d.value, t.RequestTimeout)
+			}
+		}
+	}
+	if t.DialTimeout > 0 && t.DialAddressTimeout > t.DialTimeout {
+		v.addf("DialAddressTimeout %v is longer than DialTimeout %v, which fires first", t.DialAddressTimeout, t.DialTimeout)
+	}
+
+	if t.MaxTries != 0 && !t.RetryAfterTimeout {
+		v.addf("MaxTries is set without RetryAfterTimeout, so timed out requests are not retried")
+	}
+	if t.RetryAfterTimeout && t.MaxTries == 0 {
+		v.addf("RetryAfterTimeout has no effect without MaxTries")
+	}
+
+	maxIdlePerHost := t.MaxIdleConnsPerHost
+	if maxIdlePerHost == 0 {
+		maxIdlePerHost = http.DefaultMaxIdleConnsPerHost
+	}
+	if t.MaxIdleConns > 0 && t.MaxIdleConnsPerHost > t.MaxIdleConns {
+		v.addf("MaxIdleConnsPerHost %d is larger than MaxIdleConns %d", t.MaxIdleConnsPerHost, t.MaxIdleConns)
+	}
+	if t.MaxConnsPerHost > 0 && t.MaxIdleConnsPerHost > t.MaxConnsPerHost {
+		v.addf("MaxIdleConnsPerHost %d is larger than MaxConnsPerHost %d", t.MaxIdleConnsPerHost, t.MaxConnsPerHost)
+	}
+	if t.MinIdleConns > maxIdlePerHost {
+		v.addf("MinIdleConns %d is larger than MaxIdleConnsPerHost %d, so warmed connections are closed after use", t.MinIdleConns, maxIdlePerHost)
+	}
+}
+
+// transport checks the settings that cannot be overridden by HostSettings.
+func (v *validator) transport(t *Transport) {
+	if t.MinIdleConns != 0 && t.DisableKeepAlives {
+		v.addf("MinIdleConns has no effect with DisableKeepAlives")
+	}
+	if t.MaxConnLifetime < 0 || t.MaxConnLifetimeJitter < 0 {
+		v.addf("MaxConnLifetime and MaxConnLifetimeJitter must not be negative")
+	}
+	if t.MaxConnLifetimeJitter != 0 && t.MaxConnLifetime == 0 {
+		v.addf("MaxConnLifetimeJitter has no effect without MaxConnLifetime")
+	}
+	if t.HTTP2PingTimeout != 0 && t.HTTP2ReadIdleTimeout == 0 {
+		v.addf("HTTP2PingTimeout has no effect without HTTP2ReadIdleTimeout")
+	}
+	if t.MaxConcurrentRequests < 0 || t.MaxQueuedRequests < 0 {
+		v.addf("MaxConcurrentRequests and MaxQueuedRequests must not be negative")
+	}
+	if t.MaxQueuedRequests != 0 && t.MaxConcurrentRequests == 0 {
+		v.addf("MaxQueuedRequests has no effect without MaxConcurrentRequests")
+	}
+	if t.MaxCoalescedBodySize < 0 {
+		v.addf("MaxCoalescedBodySize must not be negative")
+	}
+	if t.MaxCoalescedBodySize != 0 && t.CoalesceKey == nil {
+		v.addf("MaxCoalescedBodySize has no effect without CoalesceKey")
+	}
+	if t.Dial != nil && t.DNSCache != nil {
+		v.addf("DNSCache is not used when Dial is set")
+	}
+
+	if t.TLSOptions != nil {
+		config := &tls.Config{}
+		if t.TLSClientConfig != nil {
+			config = t.TLSClientConfig.Clone()
+		}
+		if err := t.TLSOptions.apply(config); err != nil {
+			v.add(err)
+		}
+		if t.ClientCert != nil && t.TLSOptions.CertFile != "" {
+			v.addf("ClientCert replaces the certificate of the TLSOptions")
+		}
+	}
+	for host, pins := range t.Pins {
+		for _, pin := range pins {
+			if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != 32 {
+				v.addf("pin %q of %s is not a base64 SHA-256 hash, see SPKIHash", pin, host)
+			}
+		}
+	}
+
+	if t.Proxy != nil && t.ProxySelector != nil && !t.ProxySelector.empty() {
+		v.addf("ProxySelector is not used when Proxy is set")
+	}
+	if s := t.ProxySelector; s != nil {
+		proxies := []string{s.HTTPProxy, s.HTTPSProxy}
+		for _, rule := range s.Rules {
+			proxies = append(proxies, rule.Proxy)
+		}
+		for _, proxy := range proxies {
+			if _, err := parseProxyURL(proxy); err != nil {
+				v.add(err)
+			}
+		}
+	}
+	if t.SOCKS5Proxy != "" {
+		if _, err := parseSOCKS5Proxy(t.SOCKS5Proxy); err != nil {
+			v.add(err)
+		}
+	}
+	for address := range t.Resolve {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			v.addf("Resolve key %q is not a host and port", address)
+		}
+	}
+
+	if h := t.HealthCheck; h != nil {
+		if h.Path == "" && h.ConsecutiveFailures == 0 && h.ErrorRate == 0 {
+			v.addf("HealthCheck never ejects hosts without Path, ConsecutiveFailures or ErrorRate")
+		}
+		if h.ErrorRate < 0 || h.ErrorRate > 1 {
+			v.addf("HealthCheck.ErrorRate %v is not between 0 and 1", h.ErrorRate)
+		}
+		if h.MinRequests != 0 && h.ErrorRate == 0 {
+			v.addf("HealthCheck.MinRequests has no effect without ErrorRate")
+		}
+		if h.baseEjectionTime() > h.maxEjectionTime() {
+			v.addf("HealthCheck.BaseEjectionTime %v is longer than MaxEjectionTime %v", h.baseEjectionTime(), h.maxEjectionTime())
+		}
+	}
+}
+
+// EffectiveConfig returns the settings of the Transport with their defaults
+// applied. Settings given as functions, such as Dial, Proxy or the Proxy of
+// HostSettings, are left out, and coalesce is true if any CoalesceKey is set.
+// Passwords, including those of proxy URLs, and the Proxy-Authorization
+// header are redacted.
+func (t *Transport) EffectiveConfig() *TransportConfig {
+	c := &TransportConfig{
+		DisableKeepAlives:      t.DisableKeepAlives,
+		DisableCompression:     t.DisableCompression,
+		MaxIdleConns:           t.MaxIdleConns,
+		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        t.MaxConnsPerHost,
+		IdleConnTimeout:        Duration(t.IdleConnTimeout),
+		MinIdleConns:           t.MinIdleConns,
+		MaxConnLifetime:        Duration(t.MaxConnLifetime),
+		MaxConnLifetimeJitter:  Duration(t.MaxConnLifetimeJitter),
+		DialTimeout:            Duration(t.DialTimeout),
+		DialKeepAlive:          Duration(t.DialKeepAlive),
+		DialFallbackDelay:      Duration(t.DialFallbackDelay),
+		DialAddressTimeout:     Duration(t.DialAddressTimeout),
+		TLSHandshakeTimeout:    Duration(t.TLSHandshakeTimeout),
+		ResponseHeaderTimeout:  Duration(t.ResponseHeaderTimeout),
+		ExpectContinueTimeout:  Duration(t.ExpectContinueTimeout),
+		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
+		WriteBufferSize:        t.WriteBufferSize,
+		ReadBufferSize:         t.ReadBufferSize,
+		ForceAttemptHTTP2:      t.ForceAttemptHTTP2,
+		H2C:                    t.H2C,
+		HTTP2ReadIdleTimeout:   Duration(t.HTTP2ReadIdleTimeout),
+		HTTP2PingTimeout:       Duration(t.HTTP2PingTimeout),
+		RequestTimeout:         Duration(t.RequestTimeout),
+		RetryAfterTimeout:      t.RetryAfterTimeout,
+		MaxTries:               t.MaxTries,
+		Coalesce:               t.CoalesceKey != nil,
+		MaxCoalescedBodySize:   t.MaxCoalescedBodySize,
+		MaxConcurrentRequests:  t.MaxConcurrentRequests,
+		MaxQueuedRequests:      t.MaxQueuedRequests,
+		TLSSessionCacheSize:    t.TLSSessionCacheSize,
+		Pins:                   t.Pins,
+		ProxyUsername:          t.ProxyUsername,
+		SOCKS5Proxy:            redactURL(t.SOCKS5Proxy),
+		Resolve:                t.Resolve,
+		UnixSockets:            t.UnixSockets,
+		Fallbacks:              t.Fallbacks,
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
+	}
+	if c.DialFallbackDelay == 0 {
+		c.DialFallbackDelay = Duration(defaultDialFallbackDelay)
+	}
+	if c.HTTP2PingTimeout == 0 {
+		c.HTTP2PingTimeout = Duration(15 * time.Second)
+	}
+	if c.Coalesce && c.MaxCoalescedBodySize == 0 {
+		c.MaxCoalescedBodySize = defaultMaxCoalescedBodySize
+	}
+	// The net/http defaults.
+	if c.MaxResponseHeaderBytes == 0 {
+		c.MaxResponseHeaderBytes = 10 << 20
+	}
+	if c.WriteBufferSize == 0 {
+		c.WriteBufferSize = 4 << 10
+	}
+	if c.ReadBufferSize == 0 {
+		c.ReadBufferSize = 4 << 10
+	}
+	if t.ProxyPassword != "" {
+		c.ProxyPassword = "xxxxx"
+	}
+
+	tlsConfig := t.TLSClientConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	c.InsecureSkipVerify = tlsConfig.InsecureSkipVerify
+	c.TLSServerName = tlsConfig.ServerName
+	c.TLSCipherPolicy = "default"
+	if c.TLSSessionCacheSize == 0 && tlsConfig.ClientSessionCache == nil {
+		if t.ClientCert != nil || (t.TLSOptions != nil && t.TLSOptions.CertFile != "") {
+			c.TLSSessionCacheSize = -1
+		} else {
+			// The crypto/tls default.
+			c.TLSSessionCacheSize = 64
+		}
+	}
+	for name, version := range tlsVersions {
+		if version == tlsConfig.MinVersion {
+			c.TLSMinVersion = name
+		}
+	}
+	if c.TLSMinVersion == "" {
+		// The crypto/tls default for clients.
+		c.TLSMinVersion = "1.2"
+	}
+	if o := t.TLSOptions; o != nil {
+		c.CAFile = o.CAFile
+		c.ClientCert = o.CertFile
+		c.ClientKey = o.KeyFile
+		if o.MinVersion != "" {
+			c.TLSMinVersion = o.MinVersion
+		}
+		if o.ServerName != "" {
+			c.TLSServerName = o.ServerName
+		}
+		if o.CipherPolicy != "" {
+			c.TLSCipherPolicy = o.CipherPolicy
+		}
+	}
+	if t.ClientCert != nil {
+		c.ClientCert = t.ClientCert.CertFile
+		c.ClientKey = t.ClientCert.KeyFile
+	}
+
+	if s := t.ProxySelector; s != nil && t.Proxy == nil {
+		c.HTTPProxy, c.HTTPSProxy, c.NoProxy = s.HTTPProxy, s.HTTPSProxy, s.NoProxy
+		if s.FromEnvironment {
+			c.ProxyFromEnv = true
+			if c.HTTPProxy == "" {
+				c.HTTPProxy = getenv("HTTP_PROXY")
+			}
+			if c.HTTPSProxy == "" {
+				c.HTTPSProxy = getenv("HTTPS_PROXY")
+			}
+			if c.NoProxy == "" {
+				c.NoProxy = getenv("NO_PROXY")
+			}
+		}
+		c.HTTPProxy, c.HTTPSProxy = redactURL(c.HTTPProxy), redactURL(c.HTTPSProxy)
+		for _, rule := range s.Rules {
+			rule.Proxy = redactURL(rule.Proxy)
+			c.ProxyRules = append(c.ProxyRules, rule)
+		}
+	}
+	if len(t.ProxyConnectHeader) != 0 {
+		c.ProxyConnectHeader = make(map[string]string, len(t.ProxyConnectHeader))
+		for k, v := range t.ProxyConnectHeader {
+			c.ProxyConnectHeader[k] = strings.Join(v, ", ")
+		}
+		if _, ok := c.ProxyConnectHeader["Proxy-Authorization"]; ok {
+			c.ProxyConnectHeader["Proxy-Authorization"] = "xxxxx"
+		}
+	}
+
+	if d := t.DNSCache; d != nil {
+		c.DNSCache = &DNSCacheConfig{
+			TTL:         Duration(d.ttl()),
+			NegativeTTL: Duration(d.negativeTTL()),
+			MaxStale:    Duration(d.maxStale()),
+		}
+	}
+	if h := t.HealthCheck; h != nil {
+		c.HealthCheck = &HealthCheckConfig{
+			Path:                h.Path,
+			Interval:            Duration(h.interval()),
+			ConsecutiveFailures: h.ConsecutiveFailures,
+			ErrorRate:           h.ErrorRate,
+			MinRequests:         h.MinRequests,
+			Window:              Duration(h.window()),
+			BaseEjectionTime:    Duration(h.baseEjectionTime()),
+			MaxEjectionTime:     Duration(h.maxEjectionTime()),
+		}
+	}
+	if len(t.Hosts) != 0 {
+		c.Hosts = make(map[string]HostConfig, len(t.Hosts))
+		for pattern, s := range t.Hosts {
+			if s == nil {
+				continue
+			}
+			c.Hosts[pattern] = HostConfig{
+				RequestTimeout:        Duration(s.RequestTimeout),
+				ResponseHeaderTimeout: Duration(s.ResponseHeaderTimeout),
+				DialTimeout:           Duration(s.DialTimeout),
+				TLSHandshakeTimeout:   Duration(s.TLSHandshakeTimeout),
+				IdleConnTimeout:       Duration(s.IdleConnTimeout),
+				MaxTries:              s.MaxTries,
+				MaxIdleConnsPerHost:   s.MaxIdleConnsPerHost,
+				MaxConnsPerHost:       s.MaxConnsPerHost,
+			}
+		}
+	}
+	return c
+}
+
+// DumpConfig returns the EffectiveConfig as JSON, for logging at startup.
+// Settings that are zero, meaning they are disabled or unlimited, are left
+// out.
+func (t *Transport) DumpConfig() ([]byte, error) {
+	return json.Marshal(t.EffectiveConfig())
+}
+
+// redactURL replaces the password of a URL, which may be given without a
+// scheme as for proxies.
+func redactURL(raw string) string {
+	if !strings.Contains(raw, "://") {
+		return strings.TrimPrefix(redactURL("http://"+raw), "http://")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,298 @@
+package httpcontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// warmKey identifies where a warmed up connection can be used.
+type warmKey struct {
+	tls     bool
+	address string
+}
+
+func warmKeyFor(host string) (warmKey, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return warmKey{}, err
+	}
+	var port string
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	default:
+		return warmKey{}, fmt.Errorf("httpcontrol: cannot warm %q: not an http or https URL", host)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return warmKey{
+		tls:     u.Scheme == "https",
+		address: net.JoinHostPort(u.Hostname(), port),
+	}, nil
+}
+
+// defaultMaxParkedAge is how long connections stay parked if IdleConnTimeout
+// is not set, the same as the IdleConnTimeout of http.DefaultTransport.
+const defaultMaxParkedAge = 90 * time.Second
+
+// parkedConn is a parked connection. A background read detects the peer
+// closing it while it is parked.
+type parkedConn struct {
+	conn   net.Conn
+	parked time.Time
+	taken  bool // guarded by the warmer's lock
+	read   chan parkedRead
+}
+
+type parkedRead struct {
+	n   int
+	err error
+}
+
+// warmer holds connections established ahead of time until the http.Transport
+// dials them.
+type warmer struct {
+	mu     sync.Mutex
+	parked map[warmKey][]*parkedConn
+	hosts  map[warmKey]bool
+	topUp  sync.Once
+}
+
+func (w *warmer) park(key warmKey, conn net.Conn) {
+	p := &parkedConn{conn: conn, parked: time.Now(), read: make(chan parkedRead, 1)}
+	w.mu.Lock()
+	if w.parked == nil {
+		w.parked = make(map[warmKey][]*parkedConn)
+	}
+	w.parked[key] = append(w.parked[key], p)
+	w.mu.Unlock()
+	go w.watch(key, p)
+}
+
+// watch reads from the parked connection until it is taken. Nothing is
+// expected to arrive on an idle connection, so if the read completes before
+// then the connection is unusable and is closed. TLS connections handle the
+// post-handshake messages, such as session tickets, while reading.
+func (w *warmer) watch(key warmKey, p *parkedConn) {
+	var b [1]byte
+	n, err := p.conn.Read(b[:])
+	w.mu.Lock()
+	if p.taken {
+		w.mu.Unlock()
+		p.read <- parkedRead{n: n, err: err}
+		return
+	}
+	conns := w.parked[key]
+	for i, c := range conns {
+		if c == p {
+			w.parked[key] = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(w.parked[key]) == 0 {
+		delete(w.parked, key)
+	}
+	w.mu.Unlock()
+	p.conn.Close()
+}
+
+// take returns the most recently parked connection for the key that is still
+// usable, closing the ones that have been parked for longer than maxAge, or a
+// default age if it is zero.
+func (w *warmer) take(key warmKey, maxAge time.Duration) net.Conn {
+	if maxAge == 0 {
+		maxAge = defaultMaxParkedAge
+	}
+	for {
+		w.mu.Lock()
+		conns := w.parked[key]
+		var expired []*parkedConn
+		for len(conns) > 0 && time.Since(conns[0].parked) > maxAge {
+			expired = append(expired, conns[0])
+			conns = conns[1:]
+		}
+		var p *parkedConn
+		if len(conns) > 0 {
+			p = conns[len(conns)-1]
+			conns = conns[:len(conns)-1]
+		}
+		if len(conns) == 0 {
+			delete(w.parked, key)
+		} else {
+			w.parked[key] = conns
+		}
+		for _, c := range expired {
+			c.taken = true
+		}
+		if p != nil {
+			p.taken = true
+		}
+		w.mu.Unlock()
+
+		for _, c := range expired {
+			c.conn.Close()
+		}
+		if p == nil {
+			return nil
+		}
+		if p.stopWatching() {
+			return p.conn
+		}
+		p.conn.Close()
+	}
+}
+
+// stopWatching interrupts the background read of a taken connection, and
+// returns true if the connection is still usable.
+func (p *parkedConn) stopWatching() bool {
+	p.conn.SetReadDeadline(time.Unix(1, 0))
+	read := <-p.read
+	var netErr net.Error
+	if read.n != 0 || !errors.As(read.err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	return p.conn.SetReadDeadline(time.Time{}) == nil
+}
+
+func (w *warmer) closeAll() {
+	w.mu.Lock()
+	var conns []*parkedConn
+	for _, parked := range w.parked {
+		for _, p := range parked {
+			p.taken = true
+			conns = append(conns, p)
+		}
+	}
+	w.parked = nil
+	w.mu.Unlock()
+	for _, p := range conns {
+		p.conn.Close()
+	}
+}
+
+// Warm establishes n connections to each of the hosts and parks them until
+// requests need them, so that the first requests do not pay for DNS, TCP and
+// TLS handshakes. Hosts are given as URLs, for example "https://example.com".
+// Connections to https hosts complete the TLS handshake. Requests that go
+// through a Proxy do not use warmed up connections.
+//
+// Parked connections are closed once they are older than the
+// IdleConnTimeout, or 90 seconds if it is not set, or if the host closes them.
+//
+// If MinIdleConns is set, the Transport keeps at least that many idle
+// connections to the warmed up hosts in the background until it is shut
+// down.
+func (t *Transport) Warm(ctx context.Context, hosts []string, n int) error {
+	t.startOnce.Do(t.start)
+	if t.startErr != nil {
+		return t.startErr
+	}
+	keys := make([]warmKey, 0, len(hosts))
+	derived := make(map[*Transport][]string)
+	for _, host := range hosts {
+		key, err := warmKeyFor(host)
+		if err != nil {
+			return err
+		}
+		if d := t.forHost(key.address); d != t {
+			derived[d] = append(derived[d], host)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	for d, hosts := range derived {
+		if err := d.Warm(ctx, hosts, n); err != nil {
+			return err
+		}
+	}
+
+	if t.MinIdleConns > 0 {
+		t.warmer.mu.Lock()
+		if t.warmer.hosts == nil {
+			t.warmer.hosts = make(map[warmKey]bool)
+		}
+		for _, key := range keys {
+			t.warmer.hosts[key] = true
+		}
+		t.warmer.mu.Unlock()
+		t.warmer.topUp.Do(func() { go t.topUp() })
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(keys)*n)
+	for _, key := range keys {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(key warmKey) {
+				defer wg.Done()
+				errs <- t.warmOne(ctx, key)
+			}(key)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transport) warmOne(ctx context.Context, key warmKey) error {
+	if key.tls {
+		conn, err := t.dialTLS(ctx, "tcp", key.address)
+		if err != nil {
+			return err
+		}
+		t.warmer.park(key, conn)
+		return nil
+	}
+	conn, err := t.dial(ctx, "tcp", key.address)
+	if err != nil {
+		return err
+	}
+	t.warmer.park(key, conn)
+	return nil
+}
+
+// topUp keeps MinIdleConns idle connections to the warmed up hosts until the
+// Transport is shut down.
+func (t *Transport) topUp() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+
+		t.warmer.mu.Lock()
+		keys := make([]warmKey, 0, len(t.warmer.hosts))
+		for key := range t.warmer.hosts {
+			keys = append(keys, key)
+		}
+		t.warmer.mu.Unlock()
+
+		snapshot := t.pool.snapshot()
+		for _, key := range keys {
+			for i := snapshot[key.address].Idle; i < t.MinIdleConns; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				err := t.warmOne(ctx, key)
+				cancel()
+				if err != nil {
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package httpcontrol_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/httpcontrol"
+)
+
+func TestWarm(t *testing.T) {
+	t.Parallel()
+	var conns int32
+	server := httptest.NewUnstartedServer(sleepHandler(0))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	var handshakes int32
+	transport := &httpcontrol.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(tls.ConnectionState) error {
+				atomic.AddInt32(&handshakes, 1)
+				return nil
+			},
+		},
+	}
+	ensure.Nil(t, transport.Warm(context.Background(), []string{server.URL}, 2))
+	ensure.DeepEqual(t, atomic.LoadInt32(&handshakes), int32(2))
+	host := server.Listener.Addr().String()
+	ensure.DeepEqual(t, transport.Snapshot()[host].Idle, 2)
+
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	ensure.Nil(t, err)
+	assertResponse(res, t)
+	ensure.DeepEqual(t, atomic.LoadInt32(&handshakes), int32(2))
+	ensure.DeepEqual(t, atomic.LoadInt32(&conns), int32(2))
+}
+
+func TestWarmKeepsMinIdleConns(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(sleepHandler(0))
+	defer server.Close()
+	transport := &httpcontrol.Transport{MinIdleConns: 2}
+	defer transport.Shutdown(context.Background())
+	ensure.Nil(t, transport.Warm(context.Background(), []string{server.URL}, 1))
+
+	host := server.Listener.Addr().String()
+	deadline := time.Now().Add(5 * time.Second)
+	for transport.Snapshot()[host].Idle < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle connections were not topped up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWarmInvalidHost(t *testing.T) {
+	transport := &httpcontrol.Transport{}
+	ensure.NotNil(t, transport.Warm(context.Background(), []string{"ftp://example.com"}, 1))
+}
+
+func TestWarmDropsConnsClosedByServer(t *testing.T) {
+	t.Parallel()
+	for _, useTLS := range []bool{false, true} {
+		server := httptest.NewUnstartedServer(sleepHandler(0))
+		conns := make(chan net.Conn, 1)
+		server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				select {
+				case conns <- c:
+				default:
+				}
+			}
+		}
+		if useTLS {
+			server.StartTLS()
+		} else {
+			server.Start()
+		}
+		defer server.Close()
+
+		transport := &httpcontrol.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		ensure.Nil(t, transport.Warm(context.Background(), []string{server.URL}, 1))
+		// the server closes the warmed connection before it is used
+		(<-conns).Close()
+		host := server.Listener.Addr().String()
+		deadline := time.Now().Add(5 * time.Second)
+		for transport.Snapshot()[host].Idle != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("closed connection was not dropped")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		client := &http.Client{Transport: transport}
+		res, err := client.Get(server.URL)
+		ensure.Nil(t, err)
+		assertResponse(res, t)
+	}
+}
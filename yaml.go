@@ -0,0 +1,289 @@
+package httpcontrol
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a non-empty line of a YAML document without its comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlParser parses the subset of YAML accepted by LoadConfigYAML into the
+// values encoding/json works with. Scalars other than null are kept as
+// strings, and converted by typeYAML according to the settings they are for.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(doc string) (interface{}, error) {
+	p := new(yamlParser)
+	for i, line := range strings.Split(doc, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs are not allowed for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("yaml line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a comment starting with " #" or at the start of the
+// line, unless it is within quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// block parses the mapping or sequence at the indentation.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if strings.HasPrefix(p.lines[p.pos].text, "- ") || p.lines[p.pos].text == "-" {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if strings.HasPrefix(line.text, "- ") {
+			return nil, fmt.Errorf("yaml line %d: unexpected sequence item", line.number)
+		}
+		key, rest, ok := cutYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml line %d: expected key: value", line.number)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("yaml line %d: duplicate key %q", line.number, key)
+		}
+		p.pos++
+		value, err := p.value(line, indent, rest)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	var s []interface{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if line.text != "-" && !strings.HasPrefix(line.text, "- ") {
+			return nil, fmt.Errorf("yaml line %d: expected sequence item", line.number)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if _, _, ok := cutYAMLKey(rest); ok && !isYAMLQuoted(rest) {
+			// A mapping starting on the item line, continued at the indentation
+			// of its first key.
+			p.lines[p.pos].indent += len(line.text) - len(rest)
+			p.lines[p.pos].text = rest
+			item, err := p.mapping(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, item)
+			continue
+		}
+		p.pos++
+		item, err := p.value(line, indent, rest)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, item)
+	}
+	return s, nil
+}
+
+// value parses the value following a key or sequence marker on the line: a
+// scalar, a flow sequence, or a nested block on the following lines.
+func (p *yamlParser) value(line yamlLine, indent int, rest string) (interface{}, error) {
+	if rest != "" {
+		if strings.HasPrefix(rest, "[") {
+			return parseYAMLFlow(line, rest)
+		}
+		return parseYAMLScalar(line, rest)
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return p.block(p.lines[p.pos].indent)
+	}
+	// Sequences of a mapping may be at the same indentation as the key.
+	if p.pos < len(p.lines) && p.lines[p.pos].indent == indent &&
+		strings.HasPrefix(p.lines[p.pos].text, "- ") && !strings.HasPrefix(line.text, "- ") {
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+// cutYAMLKey splits "key: value" and "key:" lines.
+func cutYAMLKey(text string) (string, string, bool) {
+	var key string
+	if isYAMLQuoted(text) {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key, text = text[1:end+1], text[end+2:]
+		if !strings.HasPrefix(text, ":") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(text[1:]), true
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false
+		}
+		i = len(text) - 1
+	}
+	key = strings.TrimSpace(text[:i])
+	if key == "" {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[i+1:]), true
+}
+
+func isYAMLQuoted(text string) bool {
+	return strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'")
+}
+
+func parseYAMLFlow(line yamlLine, text string) (interface{}, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("yaml line %d: unterminated flow sequence", line.number)
+	}
+	s := []interface{}{}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return s, nil
+	}
+	for _, item := range strings.Split(inner, ",") {
+		v, err := parseYAMLScalar(line, strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
+
+func parseYAMLScalar(line yamlLine, text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d: invalid quoted string %s", line.number, text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("yaml line %d: invalid quoted string %s", line.number, text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	}
+	return text, nil
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// typeYAML converts the string scalars of the parsed YAML value to the types
+// encoding/json expects for typ, so that "1.2" is a string for a string
+// setting and a number for a numeric one. Values that do not convert are left
+// for encoding/json to report.
+func typeYAML(v interface{}, typ reflect.Type) interface{} {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		switch typ.Kind() {
+		case reflect.Struct:
+			for key, value := range v {
+				for i := 0; i < typ.NumField(); i++ {
+					name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+					if name == key {
+						v[key] = typeYAML(value, typ.Field(i).Type)
+						break
+					}
+				}
+			}
+		case reflect.Map:
+			for key, value := range v {
+				v[key] = typeYAML(value, typ.Elem())
+			}
+		}
+		return v
+	case []interface{}:
+		if typ.Kind() == reflect.Slice {
+			for i, value := range v {
+				v[i] = typeYAML(value, typ.Elem())
+			}
+		}
+		return v
+	case string:
+		if reflect.PtrTo(typ).Implements(jsonUnmarshalerType) {
+			return v
+		}
+		switch typ.Kind() {
+		case reflect.Bool:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return json.Number(strconv.FormatInt(n, 10))
+			}
+			if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return json.Number(strconv.FormatUint(n, 10))
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+				return json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+			}
+		}
+		return v
+	}
+	return v
+}